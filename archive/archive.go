@@ -0,0 +1,212 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/YoshihikoAbe/fsdump"
+	"github.com/klauspost/compress/zstd"
+)
+
+type archiveError string
+
+func (e archiveError) Error() string {
+	return "eapki/archive: " + string(e)
+}
+
+type Format int
+
+const (
+	Tar Format = iota
+	TarZstd
+	Zip
+)
+
+var formatNames = map[string]Format{
+	"tar":     Tar,
+	"tar.zst": TarZstd,
+	"zip":     Zip,
+}
+
+// ParseFormat returns the format with the specified name (tar, tar.zst or zip)
+func ParseFormat(name string) (Format, error) {
+	if format, ok := formatNames[name]; ok {
+		return format, nil
+	}
+	return 0, archiveError("unknown format: " + name)
+}
+
+// DetectFormat returns the format implied by a filename's extension. ok is
+// false if the filename does not have the extension of a supported format.
+func DetectFormat(filename string) (format Format, ok bool) {
+	for name, format := range formatNames {
+		if strings.HasSuffix(filename, "."+name) {
+			return format, true
+		}
+	}
+	return 0, false
+}
+
+// every entry is given the same timestamp so that archives of identical contents are identical.
+// zip can't represent dates earlier than 1980.
+var modTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Write reads every file from src, and writes them to wr as an archive.
+// Files are written in the order they are received, and are never staged on disk.
+// If a file's Reader has a Size method, it is used to determine the size of the
+// file ahead of time. Otherwise, the file is buffered in memory.
+//
+// Files that can't be read are logged and written partially, or skipped if
+// nothing could be read ahead of the header. Only errors writing to wr stop
+// the archive.
+func Write(wr io.Writer, format Format, src fsdump.FileSource) error {
+	switch format {
+	case Tar:
+		return writeTar(wr, src)
+	case TarZstd:
+		enc, err := zstd.NewWriter(wr)
+		if err != nil {
+			return err
+		}
+		if err := writeTar(enc, src); err != nil {
+			enc.Close()
+			return err
+		}
+		return enc.Close()
+	case Zip:
+		return writeZip(wr, src)
+	}
+	return archiveError("invalid format")
+}
+
+func writeTar(wr io.Writer, src fsdump.FileSource) error {
+	tw := tar.NewWriter(wr)
+	for file := src.GetFile(); file != nil; file = src.GetFile() {
+		err := writeTarFile(tw, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func writeTarFile(tw *tar.Writer, file *fsdump.File) error {
+	rd, size, err := sizedReader(file)
+	if err != nil {
+		logReadError(file, err)
+		return nil
+	}
+
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     file.Path,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  modTime,
+		Format:   tar.FormatPAX,
+	}); err != nil {
+		return err
+	}
+	er := &errReader{rd: rd}
+	n, err := io.CopyN(tw, er, size)
+	if err != nil && err != io.EOF && er.err == nil {
+		return err
+	}
+	if n < size {
+		if er.err != nil {
+			logReadError(file, er.err)
+		} else {
+			logReadError(file, io.ErrUnexpectedEOF)
+		}
+		// keep the archive readable
+		_, err = io.CopyN(tw, zeros{}, size-n)
+		return err
+	}
+
+	// read until the end of the file, so that readers that check their contents
+	// once they are exhausted get to do so
+	var b [1]byte
+	if _, err := io.ReadFull(er, b[:]); err == nil {
+		logReadError(file, archiveError("file is larger than its size"))
+	} else if err != io.EOF {
+		logReadError(file, err)
+	}
+	return nil
+}
+
+// errReader records the error returned by rd, if any
+type errReader struct {
+	rd  io.Reader
+	err error
+}
+
+func (er *errReader) Read(p []byte) (int, error) {
+	n, err := er.rd.Read(p)
+	if err != nil && err != io.EOF {
+		er.err = err
+	}
+	return n, err
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func logReadError(file *fsdump.File, err error) {
+	log.Printf("failed to archive file: %s: %v", file.Path, err)
+}
+
+func sizedReader(file *fsdump.File) (io.Reader, int64, error) {
+	if sized, ok := file.Reader.(interface{ Size() int64 }); ok {
+		return file.Reader, sized.Size(), nil
+	}
+
+	b, err := io.ReadAll(file.Reader)
+	if err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewReader(b), int64(len(b)), nil
+}
+
+func writeZip(wr io.Writer, src fsdump.FileSource) error {
+	zw := zip.NewWriter(wr)
+	for file := src.GetFile(); file != nil; file = src.GetFile() {
+		err := writeZipFile(zw, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func writeZipFile(zw *zip.Writer, file *fsdump.File) error {
+	header := &zip.FileHeader{
+		Name:     file.Path,
+		Method:   zip.Deflate,
+		Modified: modTime,
+	}
+	header.SetMode(0644)
+
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	er := &errReader{rd: file.Reader}
+	if _, err := io.Copy(w, er); err != nil {
+		if er.err == nil {
+			return err
+		}
+		logReadError(file, er.err)
+	}
+	return nil
+}
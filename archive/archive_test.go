@@ -0,0 +1,187 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/YoshihikoAbe/fsdump"
+	"github.com/klauspost/compress/zstd"
+)
+
+type testFile struct {
+	path string
+	data string
+}
+
+var testFiles = []testFile{
+	{"data/a.txt", "hello world"},
+	{"data/sub/b.bin", strings.Repeat("0123456789", 1000)},
+	{"readme", ""},
+}
+
+// sizedFile is a reader with a size known ahead of time, like the files of a dump
+type sizedFile struct {
+	*strings.Reader
+}
+
+func (rd sizedFile) Size() int64 {
+	return rd.Reader.Size()
+}
+
+// failingReader fails once rd is exhausted, before size bytes were read
+type failingReader struct {
+	rd   io.Reader
+	size int64
+}
+
+func (rd *failingReader) Read(p []byte) (int, error) {
+	n, err := rd.rd.Read(p)
+	if err == io.EOF {
+		return n, errors.New("decryption failed")
+	}
+	return n, err
+}
+
+func (rd *failingReader) Size() int64 {
+	return rd.size
+}
+
+// source returns a source of files, whose readers have a size if sized is set
+func source(files []testFile, sized bool) fsdump.FileSource {
+	ch := make(chan fsdump.File, len(files))
+	for _, f := range files {
+		var rd io.Reader = strings.NewReader(f.data)
+		if sized {
+			rd = sizedFile{strings.NewReader(f.data)}
+		}
+		ch <- fsdump.File{Reader: rd, Closer: io.NopCloser(nil), Path: f.path}
+	}
+	close(ch)
+	return &fsdump.ChannelFileSource{Chan: ch}
+}
+
+// readArchive returns the contents of the files of an archive
+func readArchive(t *testing.T, format Format, b []byte) map[string]string {
+	t.Helper()
+
+	files := map[string]string{}
+	if format == Zip {
+		zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range zr.File {
+			rd, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(rd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[f.Name] = string(data)
+		}
+		return files
+	}
+
+	var rd io.Reader = bytes.NewReader(b)
+	if format == TarZstd {
+		dec, err := zstd.NewReader(rd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dec.Close()
+		rd = dec
+	}
+	tr := tar.NewReader(rd)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name] = string(data)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	for _, format := range []Format{Tar, TarZstd, Zip} {
+		var archives [][]byte
+		for _, sized := range []bool{false, true} {
+			buf := &bytes.Buffer{}
+			if err := Write(buf, format, source(testFiles, sized)); err != nil {
+				t.Fatal(err)
+			}
+			archives = append(archives, buf.Bytes())
+		}
+		if !bytes.Equal(archives[0], archives[1]) {
+			t.Errorf("%d: archives of identical contents differ", format)
+		}
+
+		files := readArchive(t, format, archives[0])
+		for _, f := range testFiles {
+			if files[f.path] != f.data {
+				t.Errorf("%d: %s: invalid contents", format, f.path)
+			}
+		}
+	}
+}
+
+func TestWriteReadError(t *testing.T) {
+	for _, format := range []Format{Tar, TarZstd, Zip} {
+		ch := make(chan fsdump.File, 3)
+		ch <- fsdump.File{Reader: sizedFile{strings.NewReader("first")}, Closer: io.NopCloser(nil), Path: "first"}
+		ch <- fsdump.File{
+			Reader: &failingReader{strings.NewReader("half"), 8},
+			Closer: io.NopCloser(nil),
+			Path:   "broken",
+		}
+		ch <- fsdump.File{Reader: strings.NewReader("last"), Closer: io.NopCloser(nil), Path: "last"}
+		close(ch)
+
+		buf := &bytes.Buffer{}
+		if err := Write(buf, format, &fsdump.ChannelFileSource{Chan: ch}); err != nil {
+			t.Fatal(err)
+		}
+		files := readArchive(t, format, buf.Bytes())
+		if files["first"] != "first" || files["last"] != "last" {
+			t.Errorf("%d: files after a read error weren't written: %q", format, files)
+		}
+	}
+}
+
+func TestFormats(t *testing.T) {
+	for filename, expected := range map[string]Format{
+		"dump.tar":     Tar,
+		"dump.tar.zst": TarZstd,
+		"dump.zip":     Zip,
+	} {
+		if format, ok := DetectFormat(filename); !ok || format != expected {
+			t.Errorf("%s: detected %d", filename, format)
+		}
+	}
+	for _, filename := range []string{"dump", "dump.zst", "dump.tar.gz", "tar"} {
+		if _, ok := DetectFormat(filename); ok {
+			t.Errorf("%s: format detected", filename)
+		}
+	}
+
+	for name, expected := range formatNames {
+		if format, err := ParseFormat(name); err != nil || format != expected {
+			t.Errorf("%s: parsed %d", name, format)
+		}
+	}
+	if _, err := ParseFormat("rar"); err == nil {
+		t.Error("unknown format parsed")
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package dedup
+
+import (
+	"errors"
+	"os"
+)
+
+// clone makes dst share the contents of src
+func clone(dst, src *os.File) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,230 @@
+package dedup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/YoshihikoAbe/fsdump"
+)
+
+type dedupError string
+
+func (e dedupError) Error() string {
+	return "eapki/dedup: " + string(e)
+}
+
+// Mode selects how duplicate files are linked to each other
+type Mode int
+
+const (
+	// Hardlink replaces duplicates with hard links. Modifying one copy of a
+	// file modifies every copy, so dumps should be treated as read-only.
+	Hardlink Mode = iota
+	// Reflink replaces duplicates with copy-on-write clones, which share
+	// storage but can be modified independently. It requires a filesystem
+	// that supports them, such as Btrfs or XFS.
+	Reflink
+)
+
+var modeNames = map[string]Mode{
+	"hardlink": Hardlink,
+	"reflink":  Reflink,
+}
+
+// ParseMode returns the mode with the specified name (hardlink or reflink)
+func ParseMode(name string) (Mode, error) {
+	if mode, ok := modeNames[name]; ok {
+		return mode, nil
+	}
+	return 0, dedupError("unknown mode: " + name)
+}
+
+// File is a file of a dump, identified by the SHA-256 of its contents
+type File struct {
+	// Path is the location of the file relative to the root of the dump, separated by slashes
+	Path   string
+	SHA256 string
+}
+
+type Options struct {
+	Mode Mode
+
+	// Store, if set, is a content-addressed store shared by several dumps.
+	// Files are linked to the object of the same contents in the store, and
+	// added to it if there is none, so each distinct file is only stored once.
+	Store string
+}
+
+// Result summarizes the outcome of a deduplication
+type Result struct {
+	// Linked is the number of files that were replaced with a link
+	Linked int `json:"linked"`
+	// Saved is the total size of the files that were replaced with a link
+	Saved int64 `json:"saved"`
+	// Failed lists the files that could not be deduplicated
+	Failed []string `json:"failed"`
+}
+
+// Dedupe links the files of the dump at root that have identical contents
+func Dedupe(root string, files []File, opts *Options) *Result {
+	if opts == nil {
+		opts = &Options{}
+	}
+	result := &Result{Failed: []string{}}
+	seen := map[string]string{}
+	for _, f := range files {
+		name := filepath.Join(root, filepath.FromSlash(f.Path))
+		size, err := dedupeFile(name, f.SHA256, seen, opts)
+		if err != nil {
+			log.Println(f.Path+":", err)
+			result.Failed = append(result.Failed, f.Path)
+		} else if size >= 0 {
+			result.Linked++
+			result.Saved += size
+		}
+	}
+	return result
+}
+
+// dedupeFile replaces name with a link to a file of the same contents. The size
+// of the file is returned if it was replaced, and -1 otherwise.
+func dedupeFile(name, sum string, seen map[string]string, opts *Options) (int64, error) {
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != 32 {
+		return -1, dedupError("invalid SHA-256: " + sum)
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return -1, err
+	}
+
+	original, ok := seen[sum]
+	if opts.Store != "" {
+		original = filepath.Join(opts.Store, sum[:2], sum)
+		originalInfo, err := os.Stat(original)
+		if err == nil && os.SameFile(info, originalInfo) {
+			// already linked by a previous run
+			return -1, nil
+		}
+		if err == nil {
+			// don't spread an object that was modified through a link
+			err = checkObject(original, sum)
+		}
+		switch {
+		case err == nil:
+		case errors.Is(err, errCorruptObject), errors.Is(err, fs.ErrNotExist):
+			if err == errCorruptObject {
+				log.Println(original+":", err)
+			}
+			// first intact copy of the contents
+			if err := os.MkdirAll(filepath.Dir(original), 0777); err != nil {
+				return -1, err
+			}
+			return -1, link(name, original, opts.Mode)
+		default:
+			return -1, err
+		}
+	} else if !ok {
+		seen[sum] = name
+		return -1, nil
+	}
+
+	originalInfo, err := os.Stat(original)
+	if err != nil {
+		return -1, err
+	}
+	if os.SameFile(info, originalInfo) {
+		// already linked by a previous run
+		return -1, nil
+	}
+	if info.Size() != originalInfo.Size() {
+		return -1, dedupError("size differs from " + original)
+	}
+	return info.Size(), link(original, name, opts.Mode)
+}
+
+var errCorruptObject = dedupError("object doesn't match its hash, replacing it")
+
+// checkObject checks that the contents of the store object at name match sum
+func checkObject(name, sum string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != sum {
+		return errCorruptObject
+	}
+	return nil
+}
+
+// link replaces dst with a link to src
+func link(src, dst string, mode Mode) error {
+	tmp := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".dedup")
+	os.Remove(tmp)
+
+	var err error
+	if mode == Reflink {
+		err = cloneFile(src, tmp)
+	} else {
+		err = os.Link(src, tmp)
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func cloneFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	if err := clone(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// BreakLinks returns a source of the files of src that removes the copy of each
+// file in dest before it is written. Writing over a file in place would modify
+// every file it is linked to, including the objects of a store.
+func BreakLinks(src fsdump.FileSource, dest string) fsdump.FileSource {
+	return &breakLinksSource{src, dest}
+}
+
+type breakLinksSource struct {
+	src  fsdump.FileSource
+	dest string
+}
+
+func (source *breakLinksSource) GetFile() *fsdump.File {
+	f := source.src.GetFile()
+	if f != nil {
+		name := filepath.Join(source.dest, filepath.FromSlash(f.Path))
+		if info, err := os.Lstat(name); err == nil && info.Mode().IsRegular() {
+			os.Remove(name)
+		}
+	}
+	return f
+}
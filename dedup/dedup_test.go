@@ -0,0 +1,163 @@
+package dedup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/YoshihikoAbe/fsdump"
+)
+
+// writeDump writes files to a temporary directory, and returns it along with their hashes
+func writeDump(t *testing.T, files map[string]string) (string, []File) {
+	t.Helper()
+
+	root := t.TempDir()
+	list := []File{}
+	for name, data := range files {
+		full := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(full), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256([]byte(data))
+		list = append(list, File{name, hex.EncodeToString(sum[:])})
+	}
+	return root, list
+}
+
+func sameFile(t *testing.T, a, b string) bool {
+	t.Helper()
+
+	ai, err := os.Stat(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return os.SameFile(ai, bi)
+}
+
+func TestDedupe(t *testing.T) {
+	root, files := writeDump(t, map[string]string{
+		"a.txt":     "duplicate",
+		"sub/b.txt": "duplicate",
+		"c.txt":     "unique",
+	})
+	files = append(files, File{"c.txt", "../../escape"})
+
+	result := Dedupe(root, files, nil)
+	if result.Linked != 1 || result.Saved != int64(len("duplicate")) || len(result.Failed) != 1 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if !sameFile(t, filepath.Join(root, "a.txt"), filepath.Join(root, "sub/b.txt")) {
+		t.Fatal("duplicates weren't linked")
+	}
+	if b, _ := os.ReadFile(filepath.Join(root, "sub/b.txt")); string(b) != "duplicate" {
+		t.Fatal("invalid contents after linking")
+	}
+
+	if result := Dedupe(root, files[:3], nil); result.Linked != 0 {
+		t.Fatalf("linked files were linked again: %+v", result)
+	}
+}
+
+func TestDedupeStore(t *testing.T) {
+	store := t.TempDir()
+	opts := &Options{Store: store}
+
+	old, oldFiles := writeDump(t, map[string]string{"a.txt": "shared", "b.txt": "shared"})
+	if result := Dedupe(old, oldFiles, opts); result.Linked != 1 || len(result.Failed) != 0 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	latest, latestFiles := writeDump(t, map[string]string{"a.txt": "shared", "c.txt": "new"})
+	if result := Dedupe(latest, latestFiles, opts); result.Linked != 1 || len(result.Failed) != 0 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	if !sameFile(t, filepath.Join(old, "b.txt"), filepath.Join(latest, "a.txt")) {
+		t.Fatal("dumps don't share the object")
+	}
+	sum := latestFiles[0].SHA256
+	if latestFiles[0].Path != "a.txt" {
+		sum = latestFiles[1].SHA256
+	}
+	if !sameFile(t, filepath.Join(store, sum[:2], sum), filepath.Join(latest, "a.txt")) {
+		t.Fatal("file isn't linked to the store")
+	}
+}
+
+func TestDedupeReflink(t *testing.T) {
+	root, files := writeDump(t, map[string]string{"a": "duplicate", "b": "duplicate"})
+	// support depends on the filesystem of the temporary directory
+	if err := cloneFile(filepath.Join(root, "a"), filepath.Join(root, "probe")); err != nil {
+		t.Skip("reflinks aren't supported:", err)
+	}
+
+	result := Dedupe(root, files, &Options{Mode: Reflink})
+	if result.Linked != 1 || len(result.Failed) != 0 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if sameFile(t, filepath.Join(root, "a"), filepath.Join(root, "b")) {
+		t.Fatal("reflink is a hard link")
+	}
+}
+
+func TestBreakLinks(t *testing.T) {
+	store := t.TempDir()
+	opts := &Options{Store: store}
+	old, oldFiles := writeDump(t, map[string]string{"a.txt": "shared"})
+	latest, latestFiles := writeDump(t, map[string]string{"a.txt": "shared"})
+	Dedupe(old, oldFiles, opts)
+	Dedupe(latest, latestFiles, opts)
+
+	// dump a changed file over the old dump
+	ch := make(chan fsdump.File, 1)
+	ch <- fsdump.File{Reader: strings.NewReader("changed"), Closer: io.NopCloser(nil), Path: "a.txt"}
+	close(ch)
+	dumper := fsdump.Dumper{Src: BreakLinks(&fsdump.ChannelFileSource{Chan: ch}, old), Dest: old}
+	dumper.Run()
+
+	if b, _ := os.ReadFile(filepath.Join(old, "a.txt")); string(b) != "changed" {
+		t.Fatal("file wasn't written")
+	}
+	sum := oldFiles[0].SHA256
+	for _, name := range []string{filepath.Join(latest, "a.txt"), filepath.Join(store, sum[:2], sum)} {
+		if b, _ := os.ReadFile(name); string(b) != "shared" {
+			t.Fatalf("%s was modified through a link", name)
+		}
+	}
+}
+
+func TestDedupeCorruptObject(t *testing.T) {
+	store := t.TempDir()
+	opts := &Options{Store: store}
+	old, oldFiles := writeDump(t, map[string]string{"a.txt": "shared"})
+	Dedupe(old, oldFiles, opts)
+
+	// modify the object in place, through the link
+	if err := os.WriteFile(filepath.Join(old, "a.txt"), []byte("tainted"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	latest, latestFiles := writeDump(t, map[string]string{"a.txt": "shared"})
+	if result := Dedupe(latest, latestFiles, opts); result.Linked != 0 || len(result.Failed) != 0 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	sum := latestFiles[0].SHA256
+	object := filepath.Join(store, sum[:2], sum)
+	if b, _ := os.ReadFile(object); string(b) != "shared" {
+		t.Fatal("corrupted object wasn't replaced")
+	}
+	if !sameFile(t, object, filepath.Join(latest, "a.txt")) {
+		t.Fatal("file isn't linked to the replaced object")
+	}
+}
@@ -0,0 +1,100 @@
+package drmfs
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"io"
+	"log"
+
+	"github.com/YoshihikoAbe/avsproperty"
+)
+
+// PropsMode selects how a dump writes files that are binary property documents
+type PropsMode int
+
+const (
+	// PropsRaw writes binary properties as they are
+	PropsRaw PropsMode = iota
+	// PropsConvert writes binary properties as XML in place of the original
+	PropsConvert
+	// PropsConvertKeep writes the original, and a copy converted to XML
+	// with ".xml" appended to its path
+	PropsConvertKeep
+)
+
+// isBinaryProperty reports whether header is the header of a binary property document
+func isBinaryProperty(header []byte) bool {
+	return len(header) >= 4 && header[0] == 0xa0 && header[1] == 0x42 && header[2] == ^header[3]
+}
+
+// peekProps wraps rd, and reports whether its contents are a binary property document
+func peekProps(rd io.Reader) (io.Reader, bool) {
+	br := bufio.NewReader(rd)
+	header, _ := br.Peek(4)
+	return br, isBinaryProperty(header)
+}
+
+// convertProps reads the binary property document of rec from rd, and sends it as XML
+func (state *dumpState) convertProps(rd io.Reader, rec ManifestRecord) error {
+	b, err := io.ReadAll(rd)
+	state.bytes.Add(int64(len(b)))
+	if err != nil {
+		return err
+	}
+
+	xml, err := propsToXML(b)
+	if err != nil {
+		log.Println(rec.Path+": failed to convert property:", err)
+		state.sendBuffered(b, rec, false)
+		return nil
+	}
+	state.report.convert(rec.Path)
+
+	if state.opts.Props == PropsConvertKeep {
+		state.sendBuffered(b, rec, false)
+		state.sendBuffered(xml, ManifestRecord{
+			Path:          rec.Path + ".xml",
+			EncryptedSize: rec.EncryptedSize,
+			Size:          int64(len(xml)),
+			Layer:         rec.Layer,
+		}, true)
+		return nil
+	}
+
+	rec.Size = int64(len(xml))
+	f := state.track(bytes.NewReader(xml), io.NopCloser(nil), rec)
+	f.buffered = true
+	if entry := f.expected; entry != nil {
+		// the list describes the original, so verify it now
+		sum := md5.Sum(b)
+		f.expected, f.checked = nil, true
+		f.intact = int64(len(b)) == entry.size && bytes.Equal(sum[:], entry.md5)
+	}
+	state.sendFile(f)
+	return nil
+}
+
+// sendBuffered sends b, whose bytes were already counted towards the progress of the dump.
+// extra files aren't counted towards the progress at all.
+func (state *dumpState) sendBuffered(b []byte, rec ManifestRecord, extra bool) {
+	f := state.track(bytes.NewReader(b), io.NopCloser(nil), rec)
+	f.buffered, f.extra = true, extra
+	if extra {
+		f.expected = nil
+	}
+	state.sendFile(f)
+}
+
+func propsToXML(b []byte) ([]byte, error) {
+	prop := &avsproperty.Property{}
+	if err := prop.Read(bytes.NewReader(b)); err != nil {
+		return nil, err
+	}
+	prop.Settings.Format = avsproperty.FormatPrettyXML
+	buf := &bytes.Buffer{}
+	if err := prop.Write(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
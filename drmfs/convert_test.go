@@ -0,0 +1,121 @@
+package drmfs
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/YoshihikoAbe/avsproperty"
+)
+
+// propsVolume returns a volume containing testFiles and two files that look like
+// binary properties, only the first of which is one
+func propsVolume(t *testing.T) (*Volume, []testFile) {
+	t.Helper()
+
+	prop, _ := avsproperty.NewProperty("config")
+	prop.Root.NewNodeWithValue("volume", uint32(7))
+	buf := &bytes.Buffer{}
+	if err := prop.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	kbin := buf.String()
+
+	files := append(testFiles[:len(testFiles):len(testFiles)],
+		testFile{"prop/config.xml", kbin, 1},
+		// looks like a binary property, but isn't one
+		testFile{"prop/broken.xml", "\xa0\x42\x80\x7f garbage", 2},
+	)
+	fix := newTestFixture()
+	fix.build(t, files, nil)
+	v, err := OpenFS(fix.mapFS(), fix.ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v, files
+}
+
+func TestDumpProps(t *testing.T) {
+	v, files := propsVolume(t)
+	kbin := files[len(files)-2].data
+
+	for _, mode := range []PropsMode{PropsConvert, PropsConvertKeep} {
+		var last DumpProgress
+		ch, report := v.Dump(&DumpOptions{
+			Metadata: MetadataSkip,
+			Props:    mode,
+			Progress: func(p DumpProgress) { last = p },
+		})
+		dumped := map[string]string{}
+		for f := range ch {
+			b, _ := io.ReadAll(f.Reader)
+			f.Close()
+			dumped[f.Path] = string(b)
+		}
+
+		converted := dumped["prop/config.xml"]
+		if mode == PropsConvertKeep {
+			if converted != kbin {
+				t.Errorf("%d: original wasn't kept", mode)
+			}
+			converted = dumped["prop/config.xml.xml"]
+		}
+		if !strings.Contains(converted, `<volume __type="u32">7</volume>`) {
+			t.Errorf("%d: invalid conversion: %q", mode, converted)
+		}
+		if dumped["prop/broken.xml"] != files[len(files)-1].data || dumped["readme"] != "top level" {
+			t.Errorf("%d: unconverted files were modified", mode)
+		}
+
+		if report.Failed() || report.TotalConverted != 1 || report.Converted[0] != "prop/config.xml" {
+			t.Errorf("%d: unexpected report: %+v", mode, report)
+		}
+		if last.Files != last.TotalFiles || last.TotalFiles != len(files) || last.Bytes != last.TotalBytes {
+			t.Errorf("%d: unexpected progress: %+v", mode, last)
+		}
+	}
+}
+
+func TestDumpPropsVerify(t *testing.T) {
+	v, files := propsVolume(t)
+
+	for _, tampered := range []bool{false, true} {
+		listed := slices.Clone(files)
+		if tampered {
+			listed[len(files)-2].data += " "
+		}
+		prop := &avsproperty.Property{}
+		if err := prop.Read(strings.NewReader(testFileList(listed))); err != nil {
+			t.Fatal(err)
+		}
+		ver, err := NewVerifier(prop.Root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		journal, err := OpenJournal(filepath.Join(t.TempDir(), "journal"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer journal.Close()
+
+		ch, report := v.Dump(&DumpOptions{
+			Metadata: MetadataSkip,
+			Props:    PropsConvert,
+			Verify:   ver,
+			Journal:  journal,
+		})
+		for f := range ch {
+			io.Copy(io.Discard, f.Reader)
+			f.Close()
+		}
+
+		const name = "prop/config.xml"
+		if report.TotalConverted != 1 || slices.Contains(report.Verified, name) == tampered ||
+			slices.Contains(report.Broken, name) != tampered || journal.Contains(name) == tampered {
+			t.Errorf("tampered %t: unexpected report: %+v", tampered, report)
+		}
+	}
+}
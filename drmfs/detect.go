@@ -0,0 +1,34 @@
+package drmfs
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+
+	"github.com/YoshihikoAbe/eapki/keyring"
+)
+
+func DetectContentsCode(root string, candidates []string) (*keyring.Info, error) {
+	return DetectContentsCodeFS(os.DirFS(root), candidates)
+}
+
+// DetectContentsCodeFS finds the contents code of the drmfs whose root
+// directory is fsys among the candidates. A candidate matches if the
+// obfuscated path of keyring.dat derived from it exists, and the contents
+// code recorded in the keyring's header agrees.
+func DetectContentsCodeFS(fsys fs.FS, candidates []string) (*keyring.Info, error) {
+	for _, code := range candidates {
+		po := PathObfuscator{}
+		po.Init(code)
+
+		b, err := fs.ReadFile(fsys, po.Obfuscate("keyring.dat"))
+		if err != nil {
+			continue
+		}
+		info, err := keyring.ReadInfo(bytes.NewReader(b))
+		if err == nil && info.Code == code {
+			return info, nil
+		}
+	}
+	return nil, drmError("contents code not found")
+}
@@ -0,0 +1,24 @@
+package drmfs
+
+import "testing"
+
+func TestDetectContentsCode(t *testing.T) {
+	fix := newTestFixture()
+	fix.build(t, testFiles, nil)
+	fsys := fix.mapFS()
+
+	if _, err := DetectContentsCodeFS(fsys, []string{"ABCD"}); err == nil {
+		t.Error("wrong contents code detected")
+	}
+	info, err := DetectContentsCodeFS(fsys, []string{"ABCD", fix.ks.Code})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Code != fix.ks.Code || info.Version != fix.ks.Version {
+		t.Errorf("unexpected info: %+v", info)
+	}
+
+	if info, err := DetectContentsCode(fix.writeDir(t), []string{fix.ks.Code}); err != nil || info.Code != fix.ks.Code {
+		t.Error("contents code of directory wasn't detected")
+	}
+}
@@ -0,0 +1,170 @@
+package drmfs
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"slices"
+	"strings"
+)
+
+// RekeyedFile is a file whose key index differs between two volumes
+type RekeyedFile struct {
+	Path   string `json:"path"`
+	OldKey uint32 `json:"old_key_idx"`
+	NewKey uint32 `json:"new_key_idx"`
+}
+
+// Diff describes the changes between two volumes. Every list is sorted by real path.
+type Diff struct {
+	Added    []string      `json:"added"`
+	Removed  []string      `json:"removed"`
+	Modified []string      `json:"modified"`
+	Rekeyed  []RekeyedFile `json:"rekeyed"`
+}
+
+// Changed returns the set of files of the new volume that were added, modified or re-keyed
+func (d *Diff) Changed() map[string]bool {
+	changed := map[string]bool{}
+	for _, name := range slices.Concat(d.Added, d.Modified) {
+		changed[name] = true
+	}
+	for _, f := range d.Rekeyed {
+		changed[f.Path] = true
+	}
+	return changed
+}
+
+type DiffOptions struct {
+	// ListPath is the location of the file integrity list inside of both volumes.
+	// Files recorded in both lists are compared by their MD5 and size, and the
+	// contents of other files are decrypted and hashed. If empty, the contents
+	// of every file are hashed.
+	ListPath string
+
+	// Workers is the number of files that are hashed concurrently. If less
+	// than one, the number of logical CPUs is used.
+	Workers int
+}
+
+// DiffVolumes compares the files of two volumes
+func DiffVolumes(oldVol, newVol *Volume, opts *DiffOptions) (*Diff, error) {
+	if opts == nil {
+		opts = &DiffOptions{}
+	}
+	oldEntries, oldList, err := oldVol.diffEntries(opts.ListPath)
+	if err != nil {
+		return nil, err
+	}
+	newEntries, newList, err := newVol.diffEntries(opts.ListPath)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Diff{
+		Added:    []string{},
+		Removed:  []string{},
+		Modified: []string{},
+		Rekeyed:  []RekeyedFile{},
+	}
+	// files that are not in both lists
+	unlisted := []string{}
+	for name, oe := range oldEntries {
+		ne, ok := newEntries[name]
+		if !ok {
+			d.Removed = append(d.Removed, name)
+			continue
+		}
+
+		if oe.Key != ne.Key {
+			d.Rekeyed = append(d.Rekeyed, RekeyedFile{name, oe.Key, ne.Key})
+		}
+		ol, ok := oldList[name]
+		nl, ok2 := newList[name]
+		if !ok || !ok2 {
+			unlisted = append(unlisted, name)
+		} else if ol.size != nl.size || !bytes.Equal(ol.md5, nl.md5) {
+			d.Modified = append(d.Modified, name)
+		}
+	}
+	for name := range newEntries {
+		if _, ok := oldEntries[name]; !ok {
+			d.Added = append(d.Added, name)
+		}
+	}
+
+	modified := make([]bool, len(unlisted))
+	(&CheckOptions{Workers: opts.Workers}).hashEach(len(unlisted), func(i int, hash hash.Hash) {
+		name := unlisted[i]
+		oldSum, err := hashEntry(oldEntries[name], hash)
+		if err != nil {
+			log.Println(err)
+			modified[i] = true
+			return
+		}
+		newSum, err := hashEntry(newEntries[name], hash)
+		if err != nil {
+			log.Println(err)
+			modified[i] = true
+			return
+		}
+		modified[i] = !bytes.Equal(oldSum, newSum)
+	})
+	for i, name := range unlisted {
+		if modified[i] {
+			d.Modified = append(d.Modified, name)
+		}
+	}
+
+	slices.Sort(d.Added)
+	slices.Sort(d.Removed)
+	slices.Sort(d.Modified)
+	slices.SortFunc(d.Rekeyed, func(a, b RekeyedFile) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return d, nil
+}
+
+// diffEntries returns the valid entries of the volume, and the contents of
+// its file integrity list, both indexed by real path
+func (v *Volume) diffEntries(listPath string) (map[string]Entry, map[string]listEntry, error) {
+	entries := map[string]Entry{}
+	for e, err := range v.Entries() {
+		if err == nil {
+			entries[e.Path] = e
+		}
+	}
+
+	list := map[string]listEntry{}
+	if listPath == "" {
+		return entries, list, nil
+	}
+	listed, err := v.readList(listPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return entries, list, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	for _, entry := range listed {
+		list[strings.TrimPrefix(entry.path, "/")] = entry
+	}
+	return entries, list, nil
+}
+
+// hashEntry returns the MD5 of the decrypted contents of e
+func hashEntry(e Entry, hash hash.Hash) ([]byte, error) {
+	rd, err := e.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	hash.Reset()
+	if _, err := io.Copy(hash, rd); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
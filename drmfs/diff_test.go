@@ -0,0 +1,48 @@
+package drmfs
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDiffVolumes(t *testing.T) {
+	newFiles := []testFile{
+		{"data/a.txt", "hello there", 1},
+		{"data/sub/b.bin", testFiles[1].data, 1},
+		{"data/new.txt", "added", 2},
+		{"readme", "top level", 1},
+	}
+
+	open := func(files []testFile) *Volume {
+		files = append(slices.Clip(files), testFile{"prop/filepath.xml", testFileList(files), 1})
+		fix := newTestFixture()
+		fix.build(t, files, nil)
+		v, err := Open(fix.writeDir(t), fix.ks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	oldVol := open(testFiles)
+	newVol := open(newFiles)
+
+	for _, listPath := range []string{"", "prop/filepath.xml"} {
+		d, err := DiffVolumes(oldVol, newVol, &DiffOptions{ListPath: listPath})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Equal(d.Added, []string{"data/new.txt"}) {
+			t.Errorf("%q: added: %v", listPath, d.Added)
+		}
+		if !slices.Equal(d.Removed, []string{"prop/plain.xml"}) {
+			t.Errorf("%q: removed: %v", listPath, d.Removed)
+		}
+		if !slices.Equal(d.Modified, []string{"data/a.txt", "prop/filepath.xml"}) {
+			t.Errorf("%q: modified: %v", listPath, d.Modified)
+		}
+		if !slices.Equal(d.Rekeyed, []RekeyedFile{{"data/sub/b.bin", 2, 1}}) {
+			t.Errorf("%q: rekeyed: %v", listPath, d.Rekeyed)
+		}
+	}
+}
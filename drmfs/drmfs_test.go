@@ -0,0 +1,334 @@
+package drmfs
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/YoshihikoAbe/avsproperty"
+	"github.com/YoshihikoAbe/eapki/keyring"
+)
+
+const testKeyCount = 3
+
+type testFile struct {
+	path string
+	data string
+	key  uint32
+}
+
+var testFiles = []testFile{
+	{"data/a.txt", "hello world", 1},
+	{"data/sub/b.bin", strings.Repeat("0123456789", 100), 2},
+	{"prop/plain.xml", "not encrypted", 0},
+	{"readme", "top level", 1},
+}
+
+// testFixture builds an encrypted filesystem from its contents
+type testFixture struct {
+	ks         keyring.MemoryKeySource
+	obfuscator PathObfuscator
+	keys       [testKeyCount][]byte
+	files      map[string][]byte
+}
+
+func newTestFixture() *testFixture {
+	fix := &testFixture{
+		ks: keyring.MemoryKeySource{
+			Code:    "TEST",
+			Version: "2024010100",
+			Master:  bytes.Repeat([]byte{0x4d}, 32),
+		},
+		files: map[string][]byte{},
+	}
+	fix.obfuscator.Init(fix.ks.Code)
+	for i := range fix.keys {
+		fix.keys[i] = bytes.Repeat([]byte{byte(i + 1)}, 32)
+	}
+	return fix
+}
+
+// build writes keyring.dat and file.inf, and encrypts files under their obfuscated paths.
+// The file list is built from fileinfo, which is generated from files when nil.
+func (fix *testFixture) build(t *testing.T, files []testFile, fileinfo *avsproperty.Node) {
+	t.Helper()
+
+	fix.files[fix.obfuscator.Obfuscate("keyring.dat")] = fix.keyring()
+
+	if fileinfo == nil {
+		fileinfo, _ = avsproperty.NewNode("fileinfo")
+		for _, f := range files {
+			fix.addFileNode(t, fileinfo, f)
+		}
+	}
+	for _, f := range files {
+		hashPath := fix.obfuscator.Obfuscate(f.path)
+		if f.key == 0 {
+			fix.files[hashPath] = []byte(f.data)
+		} else {
+			fix.files[hashPath] = fix.encrypt(f.key, []byte(f.data))
+		}
+	}
+
+	prop := &avsproperty.Property{Root: fileinfo}
+	buf := &bytes.Buffer{}
+	if err := prop.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	fix.files[fix.obfuscator.Obfuscate("file.inf")] = fix.encrypt(0, buf.Bytes())
+}
+
+func (fix *testFixture) addFileNode(t *testing.T, fileinfo *avsproperty.Node, f testFile) {
+	t.Helper()
+
+	parent := fileinfo
+	dirs := strings.Split(f.path, "/")
+	name := dirs[len(dirs)-1]
+	for _, dir := range dirs[:len(dirs)-1] {
+		var next *avsproperty.Node
+		for _, child := range parent.SearchChildren("dir") {
+			if child.AttributeValue("name") == dir {
+				next = child
+			}
+		}
+		if next == nil {
+			next, _ = parent.NewNode("dir")
+			next.SetAttribute("name", dir)
+		}
+		parent = next
+	}
+
+	node, _ := parent.NewNode("file")
+	node.SetAttribute("name", name)
+	if _, err := node.NewNodeWithValue("dst_path", avsproperty.BinValue(fix.obfuscator.Sum(f.path))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node.NewNodeWithValue("key_idx", f.key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeDir writes the fixture to a temporary directory
+func (fix *testFixture) writeDir(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, data := range fix.files {
+		name = path.Join(dir, name)
+		if err := os.MkdirAll(path.Dir(name), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func (fix *testFixture) encrypt(key uint32, data []byte) []byte {
+	return encryptContent(fix.keys[key], data)
+}
+
+func encryptContent(key, data []byte) []byte {
+	header := make([]byte, 30)
+	header[0], header[1] = 6, 3
+	iv := sha1.Sum(data)
+	copy(header[14:], iv[:])
+
+	block, _ := aes.NewCipher(key)
+	out := make([]byte, len(data))
+	cipher.NewCTR(block, header[14:]).XORKeyStream(out, data)
+	return append(header, out...)
+}
+
+// keyring serializes a keyring containing the fixture's keys
+func (fix *testFixture) keyring() []byte {
+	const (
+		headerSize = 168
+		entrySize  = 20
+		masterSize = 128
+	)
+
+	var (
+		masterOffset = headerSize + entrySize*testKeyCount
+		eakekOffset  = masterOffset + masterSize
+		kekOffset    = eakekOffset + 30
+		cekOffset    = kekOffset + 32*testKeyCount
+	)
+
+	buf := make([]byte, headerSize)
+	binary.BigEndian.PutUint32(buf[4:], uint32(len(fix.ks.Code)))
+	copy(buf[8:], fix.ks.Code)
+	binary.BigEndian.PutUint32(buf[72:], uint32(len(fix.ks.Version)))
+	copy(buf[76:], fix.ks.Version)
+	binary.BigEndian.PutUint32(buf[140:], testKeyCount)
+	binary.BigEndian.PutUint32(buf[152:], uint32(masterOffset-152))
+	binary.BigEndian.PutUint32(buf[156:], masterSize)
+	binary.BigEndian.PutUint32(buf[160:], uint32(eakekOffset-160))
+	binary.BigEndian.PutUint32(buf[164:], 30)
+
+	keks := make([]byte, 0, 32*testKeyCount)
+	ceks := []byte{}
+	for i := range testKeyCount {
+		entry := make([]byte, entrySize)
+		binary.BigEndian.PutUint32(entry, uint32(kekOffset+32*i-headerSize-entrySize*i))
+		binary.BigEndian.PutUint32(entry[4:], 32)
+		binary.BigEndian.PutUint32(entry[8:], uint32(cekOffset+62*i))
+		binary.BigEndian.PutUint32(entry[16:], 62)
+		buf = append(buf, entry...)
+
+		kek := bytes.Repeat([]byte{byte(0x80 + i)}, 32)
+		keks = append(keks, kek...)
+		ceks = append(ceks, encryptContent(kek, fix.keys[i])...)
+	}
+
+	buf = append(buf, make([]byte, masterSize)...)
+	buf = append(buf, encryptContent(fix.ks.Master, keks)...)
+	return append(buf, ceks...)
+}
+
+func openTestVolume(t *testing.T) *Volume {
+	t.Helper()
+
+	fix := newTestFixture()
+	fix.build(t, testFiles, nil)
+	v, err := Open(fix.writeDir(t), fix.ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestFS(t *testing.T) {
+	fsys := NewFS(openTestVolume(t))
+
+	expected := []string{}
+	for _, f := range testFiles {
+		expected = append(expected, f.path)
+	}
+	if err := fstest.TestFS(fsys, expected...); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range testFiles {
+		b, err := fs.ReadFile(fsys, f.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != f.data {
+			t.Fatalf("%s: invalid contents", f.path)
+		}
+	}
+
+	f, _ := fsys.Open("data/sub/b.bin")
+	defer f.Close()
+	b := make([]byte, 10)
+	if _, err := f.(io.ReaderAt).ReadAt(b, 505); err != nil || string(b) != "5678901234" {
+		t.Fatal("invalid contents at offset")
+	}
+}
+
+// mapFS returns the fixture as an in-memory filesystem
+func (fix *testFixture) mapFS() fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for name, data := range fix.files {
+		fsys[name] = &fstest.MapFile{Data: data}
+	}
+	return fsys
+}
+
+// streamFS hides the random access methods of the files of an fs.FS,
+// like the files of a zip archive
+type streamFS struct {
+	fs.FS
+}
+
+func (fsys streamFS) Open(name string) (fs.File, error) {
+	f, err := fsys.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return struct{ fs.File }{f}, nil
+}
+
+func TestDumpFS(t *testing.T) {
+	fix := newTestFixture()
+	fix.build(t, testFiles, nil)
+	fsys := streamFS{fix.mapFS()}
+
+	ch, report, err := DumpFS(fsys, fix.ks, &DumpOptions{Metadata: MetadataSkip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dumped := map[string]string{}
+	for f := range ch {
+		b, err := io.ReadAll(f.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		dumped[f.Path] = string(b)
+	}
+	for _, f := range testFiles {
+		if dumped[f.path] != f.data {
+			t.Errorf("%s: invalid contents", f.path)
+		}
+	}
+	if report.Failed() || report.TotalOK != len(testFiles) {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	v, err := OpenFS(fsys, fix.ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := fs.ReadFile(NewFS(v), "data/sub/b.bin")
+	if err != nil || string(b) != testFiles[1].data {
+		t.Error("invalid contents read through FS")
+	}
+}
+
+func TestEntries(t *testing.T) {
+	v := openTestVolume(t)
+
+	i := 0
+	for e, err := range v.Entries() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := testFiles[i]
+		if e.Path != f.path || e.Key != f.key || e.HashPath != v.obfuscator.Obfuscate(f.path) {
+			t.Fatalf("unexpected entry: %+v", e)
+		}
+
+		rd, err := e.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rd)
+		rd.Close()
+		if err != nil || string(b) != f.data {
+			t.Fatalf("%s: invalid contents", e.Path)
+		}
+		i++
+	}
+	if i != len(testFiles) {
+		t.Fatalf("%d entries", i)
+	}
+
+	for range v.Entries() {
+		break
+	}
+	if _, err := (Entry{Path: "readme"}).Open(); err == nil {
+		t.Fatal("entry without volume opened")
+	}
+}
@@ -2,10 +2,21 @@ package drmfs
 
 import (
 	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
 	"io"
+	"io/fs"
+	"iter"
 	"log"
 	"os"
-	"path"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/YoshihikoAbe/avsproperty"
 	"github.com/YoshihikoAbe/eapki/keyring"
@@ -27,150 +38,462 @@ func (err drmError) Error() string {
 	return "eapki/drmfs: " + string(err)
 }
 
-func Dump(root string, ks keyring.KeySource) (chan fsdump.File, error) {
-	state := &dumpState{
-		root: root,
-		ch:   make(chan fsdump.File, 2),
-	}
-	state.obfuscator.Init(ks.ContentsCode())
+type DumpOptions struct {
+	// Filter selects the files to dump. Files that are not selected are
+	// never opened. If nil, every file is dumped.
+	Filter *Filter
+
+	// Incremental, if set, skips files that are unchanged in the destination
+	Incremental *Incremental
+
+	// Journal, if set, skips files that it contains, and records each file
+	// once it has been completely read by the consumer
+	Journal *Journal
+
+	// Sort dumps files in the lexical order of their real paths, instead
+	// of the order they are recorded in the file list
+	Sort bool
+
+	// Manifest, if set, receives a record for each file once the consumer is done with it
+	Manifest *Manifest
+
+	// Metadata selects how keyring.dat and file.inf are dumped
+	Metadata MetadataMode
+
+	// Props selects how files that are binary property documents are dumped
+	Props PropsMode
 
-	if err := state.openKeyring(ks); err != nil {
-		return nil, err
+	// Verify, if set, checks the decrypted contents of each file it has an
+	// entry for while the file is being read by the consumer
+	Verify *Verifier
+
+	// Strict stops the dump if a name in the file list is unsafe. Otherwise,
+	// nodes with unsafe names are skipped and reported as invalid.
+	Strict bool
+
+	// Progress, if set, is called each time a file is done with the
+	// progress of the dump so far. Calls are serialized.
+	Progress func(DumpProgress)
+}
+
+func Dump(root string, ks keyring.KeySource, opts *DumpOptions) (chan fsdump.File, *DumpReport, error) {
+	return DumpFS(os.DirFS(root), ks, opts)
+}
+
+// DumpFS dumps the drmfs whose root directory is fsys
+func DumpFS(fsys fs.FS, ks keyring.KeySource, opts *DumpOptions) (chan fsdump.File, *DumpReport, error) {
+	v, err := OpenFS(fsys, ks)
+	if err != nil {
+		return nil, nil, err
 	}
-	node, err := state.openFileList()
+	ch, report := v.Dump(opts)
+	return ch, report, nil
+}
+
+// DumpContext is like Dump, but stops when ctx is canceled
+func DumpContext(ctx context.Context, root string, ks keyring.KeySource, opts *DumpOptions) (chan fsdump.File, *DumpReport, error) {
+	v, err := Open(root, ks)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	ch, report := v.DumpContext(ctx, opts)
+	return ch, report, nil
+}
+
+func (v *Volume) Dump(opts *DumpOptions) (chan fsdump.File, *DumpReport) {
+	return v.DumpContext(context.Background(), opts)
+}
+
+// DumpContext is like Dump, but stops sending files when ctx is canceled.
+// Files that were sent but not received yet are closed, and the channel is
+// closed, so the consumer may stop receiving once ctx is canceled.
+func (v *Volume) DumpContext(ctx context.Context, opts *DumpOptions) (chan fsdump.File, *DumpReport) {
+	if opts == nil {
+		opts = &DumpOptions{}
+	}
+	state := &dumpState{
+		ctx:    ctx,
+		vol:    v,
+		opts:   opts,
+		ch:     make(chan fsdump.File, 2),
+		report: newDumpReport(),
 	}
 
 	go func() {
 		defer close(state.ch)
-		state.dump(node, "")
+		defer state.drain()
+
+		metadata := state.metadata()
+		if opts.Progress != nil {
+			state.countTotals(metadata)
+		}
+		for _, m := range metadata {
+			state.sendData(m.name, m.data)
+		}
+		state.dump()
 	}()
-	return state.ch, nil
+	return state.ch, state.report
 }
 
 type dumpState struct {
-	obfuscator PathObfuscator
-	keyring    *keyring.Keyring
-	root       string
-	ch         chan fsdump.File
+	ctx    context.Context
+	vol    *Volume
+	opts   *DumpOptions
+	ch     chan fsdump.File
+	report *DumpReport
+
+	mu       sync.Mutex
+	progress DumpProgress
+	bytes    atomic.Int64
 }
 
-func (state *dumpState) dump(node *avsproperty.Node, current string) {
-	for _, child := range node.Children() {
-		filename := child.AttributeValueNodeName(nameNodeName)
-		if len(filename) == 0 {
-			log.Println("name attribute not found")
-			continue
+// drain closes the files that the consumer didn't receive before ctx was canceled
+func (state *dumpState) drain() {
+	if state.ctx.Err() == nil {
+		return
+	}
+	state.report.cancel()
+	for {
+		select {
+		case f := <-state.ch:
+			f.Close()
+		default:
+			return
 		}
+	}
+}
 
-		if entry := child.Name(); entry.Equals(dirNodeName) {
-			// recursively walk directory
-			state.dump(child, path.Join(current, filename))
-		} else if entry.Equals(fileNodeName) {
-			if err := state.dumpFile(child, path.Join(current, filename)); err != nil {
-				log.Println(err)
+func (state *dumpState) dump() {
+	entries := state.vol.Entries()
+	if state.opts.Sort {
+		entries = sortEntries(entries)
+	}
+	for e, err := range entries {
+		if err := state.dumpEntry(e, err); err != nil {
+			if state.ctx.Err() == nil {
+				log.Println("dump aborted:", err)
+				state.report.cancel()
 			}
-		} else {
-			log.Println(filename+":", "invalid file type:", entry)
+			return
 		}
 	}
 }
 
-func (state *dumpState) dumpFile(node *avsproperty.Node, realPath string) error {
-	var (
-		inPath string
-		key    uint32
-		err    error
-	)
+// sortEntries returns an iterator over entries in the lexical order of their real paths
+func sortEntries(entries iter.Seq2[Entry, error]) iter.Seq2[Entry, error] {
+	type walked struct {
+		e   Entry
+		err error
+	}
+	sorted := []walked{}
+	for e, err := range entries {
+		sorted = append(sorted, walked{e, err})
+	}
+	slices.SortStableFunc(sorted, func(a, b walked) int {
+		return strings.Compare(a.e.Path, b.e.Path)
+	})
 
-	// is the path obfuscated?
-	if child := node.SearchChildNodeName(pathNodeName); child != nil {
-		if inPath, err = formatHashPath(child.BinaryValue()); err != nil {
-			return err
+	return func(yield func(Entry, error) bool) {
+		for _, w := range sorted {
+			if !yield(w.e, w.err) {
+				return
+			}
 		}
-	} else {
-		inPath = realPath
 	}
-	file, err := os.Open(path.Join(state.root, inPath))
+}
+
+func (state *dumpState) dumpEntry(e Entry, err error) error {
+	opts := state.opts
+	if err := state.ctx.Err(); err != nil {
+		return err
+	}
+
 	if err != nil {
-		// log.Println(realPath+":", err)
+		log.Println(err)
+		state.record(ManifestRecord{Path: e.Path, Status: StatusInvalidNode})
+		if unsafe := (*UnsafeNameError)(nil); opts.Strict && errors.As(err, &unsafe) {
+			return err
+		}
 		return nil
 	}
-
-	// is the file encrypted under drmfs?
-	if child := node.SearchChildNodeName(keyNodeName); child != nil {
-		key = uint32(child.UintValue())
+	if !opts.Filter.Match(e.Path) {
+		return nil
 	}
-	rd := io.Reader(file)
-	if key != 0 {
-		if rd, err = state.keyring.MakeReader(file, key); err != nil {
-			return err
+	if opts.Journal != nil && opts.Journal.Contains(e.Path) {
+		rec := e.record(StatusUnchanged)
+		// the size counts towards the progress of the dump
+		if info, err := state.vol.Stat(e); err == nil {
+			rec.EncryptedSize = info.Size()
+			rec.Size = e.contentSize(info.Size())
+		}
+		state.record(rec)
+		return nil
+	}
+	if opts.Incremental != nil {
+		if rec, ok := opts.Incremental.unchanged(state.vol, e); ok {
+			state.record(rec)
+			return nil
 		}
 	}
 
-	state.ch <- fsdump.File{
-		Reader: rd,
-		Closer: file,
-		Path:   realPath,
+	if err := state.dumpFile(e); err != nil {
+		status := StatusDecryptFailed
+		if errors.Is(err, fs.ErrNotExist) {
+			status = StatusMissing
+		} else {
+			log.Println(e.Path+":", err)
+		}
+		state.record(e.record(status))
 	}
-	return nil
+	return state.ctx.Err()
 }
 
-func (state *dumpState) openKeyring(ks keyring.KeySource) error {
-	rd, err := state.readFile("keyring.dat", -1)
+func (state *dumpState) dumpFile(e Entry) error {
+	rd, file, err := state.vol.open(e)
 	if err != nil {
 		return err
 	}
-	kr, err := keyring.New(rd, ks)
+
+	info, err := file.Stat()
 	if err != nil {
+		file.Close()
 		return err
 	}
 
-	state.keyring = kr
+	rec := e.record("")
+	rec.EncryptedSize = info.Size()
+	rec.Size = e.contentSize(info.Size())
+	if state.opts.Props != PropsRaw {
+		var isProps bool
+		if rd, isProps = peekProps(rd); isProps {
+			defer file.Close()
+			return state.convertProps(rd, rec)
+		}
+	}
+	state.send(rd, file, rec)
 	return nil
 }
 
-func (state *dumpState) openFileList() (*avsproperty.Node, error) {
-	rd, err := state.readFile("file.inf", 0)
-	if err != nil {
-		return nil, err
+type metadataFile struct {
+	name string
+	data []byte
+}
+
+// metadata returns the metadata files to dump
+func (state *dumpState) metadata() []metadataFile {
+	v := state.vol
+	switch state.opts.Metadata {
+	case MetadataSkip:
+		return nil
+	case MetadataDecode:
+		keyringInfo, fileList, err := v.decodeMetadata()
+		if err != nil {
+			log.Println("failed to decode metadata:", err)
+			return nil
+		}
+		return []metadataFile{{"keyring.dat.json", keyringInfo}, {"file.inf.xml", fileList}}
+	default:
+		return []metadataFile{{"keyring.dat", v.keyringData}, {"file.inf", v.fileListData}}
 	}
+}
 
-	prop := &avsproperty.Property{}
-	if err := prop.Read(rd); err != nil {
-		return nil, err
+// countTotals computes the number and size of the files that will be dumped
+func (state *dumpState) countTotals(metadata []metadataFile) {
+	p := &state.progress
+	for _, m := range metadata {
+		if state.opts.Filter.Match(m.name) {
+			p.TotalFiles++
+			p.TotalBytes += int64(len(m.data))
+		}
 	}
-	root := prop.Root
-	if root == nil || root.Name().String() != "fileinfo" {
-		return nil, drmError("invalid root node in file list")
+	for e, err := range state.vol.Entries() {
+		if err == nil && !state.opts.Filter.Match(e.Path) {
+			continue
+		}
+		p.TotalFiles++
+		if err == nil {
+			if info, err := state.vol.Stat(e); err == nil {
+				p.TotalBytes += e.contentSize(info.Size())
+			}
+		}
 	}
-	return root, nil
 }
 
-func (state *dumpState) readFile(filename string, key int64) (*bytes.Reader, error) {
-	f, err := os.Open(path.Join(state.root, state.obfuscator.Obfuscate(filename)))
-	if err != nil {
-		return nil, err
+func (state *dumpState) sendData(filename string, b []byte) {
+	opts := state.opts
+	if !opts.Filter.Match(filename) {
+		return
+	}
+	rec := ManifestRecord{
+		Path:          filename,
+		EncryptedSize: int64(len(b)),
+		Size:          int64(len(b)),
+		// the metadata of an overlay is that of its last volume
+		Layer: max(len(state.vol.layers)-1, 0),
+	}
+	if opts.Journal != nil && opts.Journal.Contains(filename) {
+		rec.Status = StatusUnchanged
+		state.record(rec)
+		return
+	}
+	state.send(bytes.NewReader(b), io.NopCloser(nil), rec)
+}
+
+func (state *dumpState) send(rd io.Reader, closer io.Closer, rec ManifestRecord) {
+	state.sendFile(state.track(rd, closer, rec))
+}
+
+func (state *dumpState) track(rd io.Reader, closer io.Closer, rec ManifestRecord) *trackedFile {
+	f := &trackedFile{
+		rd:     rd,
+		closer: closer,
+		state:  state,
+		rec:    rec,
 	}
-	defer f.Close()
+	if state.opts.Manifest != nil {
+		f.hash = sha256.New()
+	}
+	if entry, ok := state.opts.Verify.lookup(rec.Path); ok {
+		f.expected = &entry
+		f.md5 = md5.New()
+	}
+	return f
+}
 
-	rd := io.Reader(f)
-	if key >= 0 {
-		rd, err = state.keyring.MakeReader(f, 0)
-		if err != nil {
-			return nil, err
+func (state *dumpState) sendFile(f *trackedFile) {
+	select {
+	case state.ch <- fsdump.File{Reader: f, Closer: f, Path: f.rec.Path}:
+	case <-state.ctx.Done():
+		f.closer.Close()
+	}
+}
+
+// finish is called once the consumer closes a file
+func (state *dumpState) finish(f *trackedFile) {
+	rec := f.rec
+	switch {
+	case f.err != nil:
+		rec.Status = StatusDecryptFailed
+	case !f.eof:
+		rec.Status = StatusWriteFailed
+	default:
+		rec.Status = StatusOK
+		if f.hash != nil {
+			rec.SHA256 = hex.EncodeToString(f.hash.Sum(nil))
+		}
+		if f.expected != nil {
+			f.checked = true
+			f.intact = f.read == f.expected.size && bytes.Equal(f.md5.Sum(nil), f.expected.md5)
+		}
+		if f.checked {
+			if !f.intact {
+				// don't let a resumed dump skip the file
+				rec.Status = StatusBroken
+				break
+			}
+			state.report.verified(rec.Path)
+		}
+		if state.opts.Journal != nil {
+			if err := state.opts.Journal.Add(rec.Path); err != nil {
+				log.Println(err)
+			}
 		}
 	}
-	b, err := io.ReadAll(rd)
-	if err != nil {
-		return nil, err
+	if f.extra {
+		state.account(rec)
+	} else {
+		state.record(rec)
+	}
+}
+
+func (state *dumpState) record(rec ManifestRecord) {
+	state.account(rec)
+	state.advance(rec)
+}
+
+// account adds rec to the report and manifest
+func (state *dumpState) account(rec ManifestRecord) {
+	state.report.add(rec.Path, rec.Status)
+	if state.vol.layers != nil {
+		state.report.setLayer(rec.Path, rec.Layer)
+	}
+	if state.opts.Manifest != nil {
+		if err := state.opts.Manifest.Write(rec); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// advance reports the progress of the dump after the file of rec is done
+func (state *dumpState) advance(rec ManifestRecord) {
+	if state.opts.Progress != nil {
+		// unchanged files are done without being read
+		if rec.Status == StatusUnchanged {
+			state.bytes.Add(rec.Size)
+		}
+
+		state.mu.Lock()
+		state.progress.Files++
+		state.progress.Bytes = state.bytes.Load()
+		state.opts.Progress(state.progress)
+		state.mu.Unlock()
 	}
+}
+
+// trackedFile notifies its dumpState when the consumer is done with it
+type trackedFile struct {
+	rd     io.Reader
+	closer io.Closer
+	state  *dumpState
+	rec    ManifestRecord
+
+	hash hash.Hash
+	eof  bool
+	err  error
+
+	// set if the file is verified while it is read
+	expected *listEntry
+	md5      hash.Hash
+	read     int64
+
+	// checked is set if the file was verified, and intact if its contents
+	// match the file integrity list
+	checked bool
+	intact  bool
+
+	// buffered is set if the bytes of the file were counted before it was sent,
+	// and extra if it isn't in the file list and doesn't count towards progress
+	buffered bool
+	extra    bool
+}
 
-	state.ch <- fsdump.File{
-		Reader: bytes.NewReader(b),
-		Closer: io.NopCloser(nil),
-		Path:   filename,
+// Size returns the size of the decrypted file
+func (f *trackedFile) Size() int64 {
+	return f.rec.Size
+}
+
+func (f *trackedFile) Read(p []byte) (int, error) {
+	n, err := f.rd.Read(p)
+	if f.hash != nil {
+		f.hash.Write(p[:n])
+	}
+	if f.md5 != nil {
+		f.md5.Write(p[:n])
+		f.read += int64(n)
+	}
+	if !f.buffered {
+		f.state.bytes.Add(int64(n))
 	}
-	return bytes.NewReader(b), nil
+	if err == io.EOF {
+		f.eof = true
+	} else if err != nil {
+		f.err = err
+	}
+	return n, err
+}
+
+func (f *trackedFile) Close() error {
+	err := f.closer.Close()
+	f.state.finish(f)
+	return err
 }
@@ -0,0 +1,181 @@
+package drmfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/YoshihikoAbe/avsproperty"
+	"github.com/YoshihikoAbe/eapki/archive"
+	"github.com/YoshihikoAbe/fsdump"
+)
+
+// countingFS counts the files of an fs.FS that are open
+type countingFS struct {
+	fs.FS
+	open *atomic.Int64
+}
+
+type countingFile struct {
+	fs.File
+	open *atomic.Int64
+}
+
+func (fsys countingFS) Open(name string) (fs.File, error) {
+	f, err := fsys.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fsys.open.Add(1)
+	return &countingFile{f, fsys.open}, nil
+}
+
+func (f *countingFile) Close() error {
+	f.open.Add(-1)
+	return f.File.Close()
+}
+
+func TestDumpProgress(t *testing.T) {
+	v := openTestVolume(t)
+
+	var last DumpProgress
+	calls := 0
+	ch, report := v.Dump(&DumpOptions{
+		Progress: func(p DumpProgress) {
+			calls++
+			last = p
+		},
+	})
+	for f := range ch {
+		io.Copy(io.Discard, f.Reader)
+		f.Close()
+	}
+
+	if calls != report.TotalFiles || last.Files != last.TotalFiles || last.TotalFiles != len(testFiles)+2 {
+		t.Errorf("unexpected file progress after %d calls: %+v", calls, last)
+	}
+	if last.Bytes != last.TotalBytes || last.TotalBytes == 0 {
+		t.Errorf("unexpected byte progress: %+v", last)
+	}
+}
+
+func TestDumpArchive(t *testing.T) {
+	v := openTestVolume(t)
+
+	for _, format := range []archive.Format{archive.Tar, archive.TarZstd, archive.Zip} {
+		ch, report := v.Dump(&DumpOptions{Metadata: MetadataSkip})
+		if err := archive.Write(io.Discard, format, &fsdump.ChannelFileSource{Chan: ch}); err != nil {
+			t.Fatal(err)
+		}
+		if report.Failed() || report.TotalOK != len(testFiles) {
+			t.Errorf("%d: unexpected report: %+v", format, report)
+		}
+	}
+}
+
+func TestDumpContext(t *testing.T) {
+	files := []testFile{}
+	for i := range 32 {
+		files = append(files, testFile{fmt.Sprintf("data/%d.bin", i), "contents", 1})
+	}
+	fix := newTestFixture()
+	fix.build(t, files, nil)
+	open := &atomic.Int64{}
+	v, err := OpenFS(countingFS{fix.mapFS(), open}, fix.ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, report := v.DumpContext(ctx, &DumpOptions{Metadata: MetadataSkip})
+
+	// stop consuming after the first file
+	f := <-ch
+	f.Close()
+	cancel()
+
+	received := 0
+	for f := range ch {
+		received++
+		f.Close()
+	}
+	if received >= len(files)-1 {
+		t.Error("dump wasn't stopped")
+	}
+	if !report.Canceled || !report.Failed() {
+		t.Error("cancellation not reported")
+	}
+	if n := open.Load(); n != 0 {
+		t.Errorf("%d files left open", n)
+	}
+}
+
+func TestDumpVerify(t *testing.T) {
+	v := openTestVolume(t)
+
+	listed := slices.Clone(testFiles)
+	listed[3].data = "tampered"
+	prop := &avsproperty.Property{}
+	if err := prop.Read(strings.NewReader(testFileList(listed))); err != nil {
+		t.Fatal(err)
+	}
+	ver, err := NewVerifier(prop.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	manifest := NewManifest(buf, ManifestJSONL)
+	ch, report := v.Dump(&DumpOptions{Verify: ver, Manifest: manifest, Metadata: MetadataSkip})
+	for f := range ch {
+		io.Copy(io.Discard, f.Reader)
+		f.Close()
+	}
+	manifest.Flush()
+
+	if report.TotalVerified != len(testFiles)-1 || report.TotalOK != len(testFiles)-1 ||
+		!slices.Equal(report.Broken, []string{"readme"}) || !report.Failed() {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	records, err := ReadManifest(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range records {
+		if (rec.Path == "readme") != (rec.Status == StatusBroken) {
+			t.Errorf("unexpected record: %+v", rec)
+		}
+	}
+}
+
+func TestDumpProgressJournal(t *testing.T) {
+	journal, err := OpenJournal(filepath.Join(t.TempDir(), "journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	for _, name := range []string{"keyring.dat", "data/sub/b.bin"} {
+		journal.Add(name)
+	}
+
+	var last DumpProgress
+	ch, report := openTestVolume(t).Dump(&DumpOptions{
+		Journal:  journal,
+		Progress: func(p DumpProgress) { last = p },
+	})
+	for f := range ch {
+		io.Copy(io.Discard, f.Reader)
+		f.Close()
+	}
+
+	if report.TotalUnchanged != 2 || last.Files != last.TotalFiles || last.Bytes != last.TotalBytes {
+		t.Errorf("resumed dump didn't complete: %+v", last)
+	}
+}
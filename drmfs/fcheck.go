@@ -2,74 +2,384 @@ package drmfs
 
 import (
 	"bytes"
+	"cmp"
 	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"hash"
 	"io"
+	"io/fs"
 	"os"
-	"path"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/YoshihikoAbe/avsproperty"
+	"github.com/bmatcuk/doublestar/v4"
 )
 
-var (
-	md5NodeName, _  = avsproperty.NewNodeName("dst_md5")
-	sizeNodeName, _ = avsproperty.NewNodeName("dst_size")
-)
+// listSchema names the child nodes of a file node in a file integrity list
+type listSchema struct {
+	path, md5, size string
+}
+
+// known file integrity list schemas, in order of preference
+var listSchemas = []listSchema{
+	// prop/filepath.xml
+	{"dst_path", "dst_md5", "dst_size"},
+	// allfiles.xml
+	{"path", "md5", "size"},
+}
 
 type CheckResult struct {
 	Time time.Time `json:"time"`
 
+	// Broken contains every file that is present but doesn't match the
+	// list, i.e. the union of SizeMismatch, HashMismatch and Unreadable
 	Broken       []string `json:"broken"`
 	Missing      []string `json:"missing"`
-	TotalBroken  int      `json:"total_broken"`
-	TotalMissing int      `json:"total_missing"`
-	TotalFiles   int      `json:"total_files"`
+	SizeMismatch []string `json:"size_mismatch"`
+	HashMismatch []string `json:"hash_mismatch"`
+	Unreadable   []string `json:"unreadable"`
+	// Extra contains the files under root that are not in the list
+	Extra []string `json:"extra"`
+
+	TotalBroken       int `json:"total_broken"`
+	TotalMissing      int `json:"total_missing"`
+	TotalSizeMismatch int `json:"total_size_mismatch"`
+	TotalHashMismatch int `json:"total_hash_mismatch"`
+	TotalUnreadable   int `json:"total_unreadable"`
+	TotalExtra        int `json:"total_extra"`
+	TotalIgnored      int `json:"total_ignored"`
+	TotalFiles        int `json:"total_files"`
 }
 
-func CheckContents(list *avsproperty.Node, root string) (*CheckResult, error) {
-	if list.Name().String() != "list" {
-		return nil, drmError("invalid root node")
-	}
+// OK reports whether every file in the list is intact. Extra files are not considered.
+func (result *CheckResult) OK() bool {
+	return result.TotalBroken == 0 && result.TotalMissing == 0
+}
 
-	result := &CheckResult{
-		Time:    time.Now(),
-		Broken:  []string{},
-		Missing: []string{},
-	}
+type CheckOptions struct {
+	// Workers is the number of files that are hashed concurrently. If less
+	// than one, the number of logical CPUs is used.
+	Workers int
+
+	// Progress, if set, is called after each file is checked, with the
+	// number of files checked so far and the total number of files.
+	// Calls are serialized.
+	Progress func(done, total int)
+
+	// Ignore contains doublestar glob patterns (e.g. dev/nvram/**) of files
+	// that are expected to vary. Matching files are neither checked nor
+	// reported as extra.
+	Ignore []string
+
+	// Filter, if set, selects the listed files to check. Listed files that
+	// it doesn't select are skipped like ignored files.
+	Filter *Filter
+}
+
+// escapeGlob escapes the doublestar metacharacters of a literal path
+func escapeGlob(name string) string {
+	return globEscaper.Replace(strings.TrimPrefix(name, "/"))
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`, "{", `\{`, "}", `\}`)
+
+func (opts *CheckOptions) ignored(name string) bool {
+	return matchGlobs(opts.Ignore, name)
+}
 
-	hash := md5.New()
+type checkStatus int
+
+const (
+	checkOK checkStatus = iota
+	checkMissing
+	checkSizeMismatch
+	checkHashMismatch
+	checkUnreadable
+)
+
+// listEntry is a file recorded in a file integrity list
+type listEntry struct {
+	path string
+	md5  []byte
+	size int64
+}
+
+// readList reads a file integrity list. Its schema is detected from the first file node.
+func readList(list *avsproperty.Node) ([]listEntry, error) {
+	var schema *listSchema
+	entries := []listEntry{}
 	for _, entry := range list.Children() {
 		if !entry.Name().Equals(fileNodeName) {
 			continue
 		}
-		result.TotalFiles++
+		if schema == nil {
+			if schema = detectListSchema(entry); schema == nil {
+				return nil, drmError("unrecognized file list schema")
+			}
+		}
 
-		pathNode := entry.SearchChildNodeName(pathNodeName)
-		md5Node := entry.SearchChildNodeName(md5NodeName)
-		sizeNode := entry.SearchChildNodeName(sizeNodeName)
+		pathNode := entry.SearchChild(schema.path)
+		md5Node := entry.SearchChild(schema.md5)
+		sizeNode := entry.SearchChild(schema.size)
 		if pathNode == nil || md5Node == nil || sizeNode == nil {
 			return nil, drmError("invalid file node in list")
 		}
 
-		filename := pathNode.StringValue()
-		rd, err := os.Open(path.Join(root, filename))
+		sum, err := listMD5(md5Node)
 		if err != nil {
+			return nil, err
+		}
+		size, err := listSize(sizeNode)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, listEntry{
+			path: pathNode.StringValue(),
+			md5:  sum,
+			size: size,
+		})
+	}
+	if schema == nil {
+		return nil, drmError("no files in list")
+	}
+	return entries, nil
+}
+
+// listFiles reads a file integrity list, and indexes its entries by real path
+func listFiles(list *avsproperty.Node) (map[string]listEntry, error) {
+	entries, err := readList(list)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]listEntry, len(entries))
+	for _, entry := range entries {
+		files[strings.TrimPrefix(entry.path, "/")] = entry
+	}
+	return files, nil
+}
+
+func detectListSchema(entry *avsproperty.Node) *listSchema {
+	for i, schema := range listSchemas {
+		if entry.SearchChild(schema.path) != nil &&
+			entry.SearchChild(schema.md5) != nil &&
+			entry.SearchChild(schema.size) != nil {
+			return &listSchemas[i]
+		}
+	}
+	return nil
+}
+
+// listMD5 returns the value of an MD5 node, which is stored either as binary or as a hex string
+func listMD5(node *avsproperty.Node) ([]byte, error) {
+	if b := node.BinaryValue(); b != nil {
+		return b, nil
+	}
+	b, err := hex.DecodeString(node.StringValue())
+	if err != nil || len(b) != md5.Size {
+		return nil, drmError("invalid md5 in list")
+	}
+	return b, nil
+}
+
+// listSize returns the value of a size node, which is stored as an integer of any width or as a string
+func listSize(node *avsproperty.Node) (int64, error) {
+	switch node.Value().(type) {
+	case int8, int16, int32, int64:
+		return node.IntValue(), nil
+	case string:
+		size, err := strconv.ParseInt(node.StringValue(), 10, 64)
+		if err != nil {
+			return 0, drmError("invalid size in list")
+		}
+		return size, nil
+	default:
+		return int64(node.UintValue()), nil
+	}
+}
+
+func CheckContents(list *avsproperty.Node, root string, opts *CheckOptions) (*CheckResult, error) {
+	return CheckContentsFS(list, os.DirFS(root), opts)
+}
+
+// CheckContentsFS verifies the files of fsys against the file integrity list
+func CheckContentsFS(list *avsproperty.Node, fsys fs.FS, opts *CheckOptions) (*CheckResult, error) {
+	entries, err := readList(list)
+	if err != nil {
+		return nil, err
+	}
+	return checkContents(entries, fsys, opts)
+}
+
+// CheckContents verifies the files of the volume against the file list stored
+// at listPath inside of it. Files are decrypted on the fly, so no dump is required.
+func (v *Volume) CheckContents(listPath string, opts *CheckOptions) (*CheckResult, error) {
+	entries, err := v.readList(listPath)
+	if err != nil {
+		return nil, err
+	}
+	// the list doesn't contain itself
+	opts = cmp.Or(opts, &CheckOptions{})
+	withList := *opts
+	withList.Ignore = append(slices.Clip(opts.Ignore), escapeGlob(listPath))
+	return checkContents(entries, NewFS(v), &withList)
+}
+
+// readList reads the file integrity list stored at listPath inside of the volume
+func (v *Volume) readList(listPath string) ([]listEntry, error) {
+	b, err := v.ReadFile(listPath)
+	if err != nil {
+		return nil, err
+	}
+	prop := &avsproperty.Property{}
+	if err := prop.Read(bytes.NewReader(b)); err != nil {
+		return nil, err
+	}
+	return readList(prop.Root)
+}
+
+func checkContents(entries []listEntry, fsys fs.FS, opts *CheckOptions) (*CheckResult, error) {
+	if opts == nil {
+		opts = &CheckOptions{}
+	}
+	for _, pattern := range opts.Ignore {
+		if !doublestar.ValidatePattern(pattern) {
+			return nil, drmError("invalid pattern: " + pattern)
+		}
+	}
+
+	listed := map[string]bool{}
+	checked := []listEntry{}
+	ignored := 0
+	for _, entry := range entries {
+		name := strings.TrimPrefix(entry.path, "/")
+		listed[name] = true
+		if opts.ignored(name) || !opts.Filter.Match(name) {
+			ignored++
+		} else {
+			checked = append(checked, entry)
+		}
+	}
+	entries = checked
+
+	statuses := make([]checkStatus, len(entries))
+	opts.hashEach(len(entries), func(i int, hash hash.Hash) {
+		statuses[i] = checkFile(fsys, entries[i], hash)
+	})
+
+	result := &CheckResult{
+		Time:         time.Now(),
+		Broken:       []string{},
+		Missing:      []string{},
+		SizeMismatch: []string{},
+		HashMismatch: []string{},
+		Unreadable:   []string{},
+		Extra:        []string{},
+		TotalIgnored: ignored,
+		TotalFiles:   len(entries),
+	}
+	for i, status := range statuses {
+		filename := entries[i].path
+		switch status {
+		case checkMissing:
 			result.Missing = append(result.Missing, filename)
 			result.TotalMissing++
 			continue
+		case checkSizeMismatch:
+			result.SizeMismatch = append(result.SizeMismatch, filename)
+			result.TotalSizeMismatch++
+		case checkHashMismatch:
+			result.HashMismatch = append(result.HashMismatch, filename)
+			result.TotalHashMismatch++
+		case checkUnreadable:
+			result.Unreadable = append(result.Unreadable, filename)
+			result.TotalUnreadable++
+		default:
+			continue
 		}
+		result.Broken = append(result.Broken, filename)
+		result.TotalBroken++
+	}
 
-		if _, err := io.CopyN(hash, rd, int64(sizeNode.UintValue())); err != nil {
-			rd.Close()
-			return nil, err
+	fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// listed files under a directory that can't be read were already
+			// classified when they were checked, so only extra files are skipped
+			return nil
 		}
-		rd.Close()
-		if !bytes.Equal(md5Node.BinaryValue(), hash.Sum(nil)) {
-			result.Broken = append(result.Broken, filename)
-			result.TotalBroken++
+		if !d.IsDir() && !listed[name] && !opts.ignored(name) {
+			result.Extra = append(result.Extra, "/"+name)
+			result.TotalExtra++
 		}
-		hash.Reset()
+		return nil
+	})
+	return result, nil
+}
+
+// hashEach calls fn for the indices [0, n) on a pool of workers, each of which owns an MD5 hash
+func (opts *CheckOptions) hashEach(n int, fn func(i int, hash hash.Hash)) {
+	workers := opts.Workers
+	if workers < 1 {
+		workers = runtime.NumCPU()
 	}
 
-	return result, nil
+	next := make(chan int)
+	mu := sync.Mutex{}
+	done := 0
+
+	wg := sync.WaitGroup{}
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hash := md5.New()
+			for i := range next {
+				fn(i, hash)
+
+				if opts.Progress != nil {
+					mu.Lock()
+					done++
+					opts.Progress(done, n)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range n {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
+
+func checkFile(fsys fs.FS, entry listEntry, hash hash.Hash) checkStatus {
+	f, err := fsys.Open(strings.TrimPrefix(entry.path, "/"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return checkMissing
+	} else if err != nil {
+		return checkUnreadable
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return checkUnreadable
+	}
+	if info.Size() != entry.size {
+		return checkSizeMismatch
+	}
+
+	hash.Reset()
+	if _, err := io.Copy(hash, f); err != nil {
+		return checkUnreadable
+	}
+	if !bytes.Equal(entry.md5, hash.Sum(nil)) {
+		return checkHashMismatch
+	}
+	return checkOK
 }
@@ -0,0 +1,190 @@
+package drmfs
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"io/fs"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/YoshihikoAbe/avsproperty"
+)
+
+// testFileList generates a filepath.xml style list describing files
+func testFileList(files []testFile) string {
+	prop, _ := avsproperty.NewProperty("list")
+	for _, f := range files {
+		n, _ := prop.Root.NewNode("file")
+		n.NewNodeWithValue("dst_path", "/"+f.path)
+		sum := md5.Sum([]byte(f.data))
+		n.NewNodeWithValue("dst_md5", avsproperty.BinValue(sum[:]))
+		n.NewNodeWithValue("dst_size", uint64(len(f.data)))
+	}
+	buf := &bytes.Buffer{}
+	prop.Settings.Format = avsproperty.FormatPrettyXML
+	prop.Write(buf)
+	return buf.String()
+}
+
+func TestCheckContents(t *testing.T) {
+	sum := func(s string) []byte {
+		h := md5.Sum([]byte(s))
+		return h[:]
+	}
+
+	entries := []listEntry{
+		{"/data/ok.txt", sum("intact"), 6},
+		{"/data/short.txt", sum("truncated"), 9},
+		{"/data/hash.txt", sum("original"), 8},
+		{"/data/missing.txt", sum("gone"), 4},
+	}
+	fsys := fstest.MapFS{
+		"data/ok.txt":    {Data: []byte("intact")},
+		"data/short.txt": {Data: []byte("trunc")},
+		"data/hash.txt":  {Data: []byte("modified")},
+		"data/extra.txt": {Data: []byte("unlisted")},
+		"dev/nvram/0":    {Data: []byte("varies")},
+	}
+
+	calls := 0
+	result, err := checkContents(entries, fsys, &CheckOptions{
+		Workers: 2,
+		Ignore:  []string{"dev/nvram/**"},
+		Progress: func(done, total int) {
+			calls++
+			if total != len(entries) || done != calls {
+				t.Errorf("unexpected progress %d/%d", done, total)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != len(entries) {
+		t.Errorf("progress called %d times", calls)
+	}
+	if !slices.Equal(result.SizeMismatch, []string{"/data/short.txt"}) {
+		t.Error("size mismatch:", result.SizeMismatch)
+	}
+	if !slices.Equal(result.HashMismatch, []string{"/data/hash.txt"}) {
+		t.Error("hash mismatch:", result.HashMismatch)
+	}
+	if !slices.Equal(result.Missing, []string{"/data/missing.txt"}) {
+		t.Error("missing:", result.Missing)
+	}
+	if !slices.Equal(result.Broken, []string{"/data/short.txt", "/data/hash.txt"}) {
+		t.Error("broken:", result.Broken)
+	}
+	if !slices.Equal(result.Extra, []string{"/data/extra.txt"}) {
+		t.Error("extra:", result.Extra)
+	}
+	if result.OK() || result.TotalFiles != 4 {
+		t.Error("unexpected totals:", result.TotalFiles)
+	}
+}
+
+// lockedFS fails to read the directory locked
+type lockedFS struct {
+	fstest.MapFS
+}
+
+func (fsys lockedFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name == "locked" {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrPermission}
+	}
+	return fsys.MapFS.ReadDir(name)
+}
+
+func TestCheckContentsUnreadableDir(t *testing.T) {
+	sum := md5.Sum([]byte("intact"))
+	entries := []listEntry{
+		{"/data/ok.txt", sum[:], 6},
+		{"/locked/ok.txt", sum[:], 6},
+	}
+	fsys := lockedFS{fstest.MapFS{
+		"data/ok.txt":      {Data: []byte("intact")},
+		"data/extra.txt":   {Data: []byte("unlisted")},
+		"locked/ok.txt":    {Data: []byte("intact")},
+		"locked/extra.txt": {Data: []byte("unlisted")},
+	}}
+
+	result, err := checkContents(entries, fsys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.OK() || !slices.Equal(result.Extra, []string{"/data/extra.txt"}) {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
+
+func TestVolumeCheckContents(t *testing.T) {
+	listed := append([]testFile{}, testFiles...)
+	listed[1].data = "tampered"
+	listed = append(listed, testFile{"data/gone.txt", "never stored", 1})
+
+	files := append([]testFile{}, testFiles...)
+	files = append(files, testFile{"prop/filepath.xml", testFileList(listed), 1})
+	fix := newTestFixture()
+	fix.build(t, files, nil)
+	v, err := Open(fix.writeDir(t), fix.ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := v.CheckContents("prop/filepath.xml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(result.SizeMismatch, []string{"/data/sub/b.bin"}) {
+		t.Error("size mismatch:", result.SizeMismatch)
+	}
+	if result.TotalHashMismatch != 0 || result.TotalUnreadable != 0 {
+		t.Error("unexpected hash mismatch or unreadable files")
+	}
+	if !slices.Equal(result.Missing, []string{"/data/gone.txt"}) {
+		t.Error("missing:", result.Missing)
+	}
+	if result.TotalExtra != 0 {
+		t.Error("extra:", result.Extra)
+	}
+	if result.TotalFiles != len(listed) {
+		t.Error("total files:", result.TotalFiles)
+	}
+}
+
+func TestReadListSchemas(t *testing.T) {
+	sum := md5.Sum([]byte("hello world"))
+
+	filepath, _ := avsproperty.NewNode("list")
+	n, _ := filepath.NewNode("file")
+	n.NewNodeWithValue("dst_path", "/data/a.txt")
+	n.NewNodeWithValue("dst_md5", avsproperty.BinValue(sum[:]))
+	n.NewNodeWithValue("dst_size", uint32(11))
+
+	allfiles, _ := avsproperty.NewNode("allfiles")
+	n, _ = allfiles.NewNode("file")
+	n.NewNodeWithValue("path", "/data/a.txt")
+	n.NewNodeWithValue("md5", hex.EncodeToString(sum[:]))
+	n.NewNodeWithValue("size", int64(11))
+
+	for _, list := range []*avsproperty.Node{filepath, allfiles} {
+		entries, err := readList(list)
+		if err != nil {
+			t.Fatal(list.Name(), err)
+		}
+		if len(entries) != 1 || entries[0].path != "/data/a.txt" ||
+			!bytes.Equal(entries[0].md5, sum[:]) || entries[0].size != 11 {
+			t.Errorf("%s: unexpected entries: %v", list.Name(), entries)
+		}
+	}
+
+	unknown, _ := avsproperty.NewNode("list")
+	n, _ = unknown.NewNode("file")
+	n.NewNodeWithValue("name", "a.txt")
+	if _, err := readList(unknown); err == nil {
+		t.Error("unrecognized schema accepted")
+	}
+}
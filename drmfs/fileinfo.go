@@ -0,0 +1,103 @@
+package drmfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"path"
+	"strconv"
+
+	"github.com/YoshihikoAbe/avsproperty"
+	"github.com/YoshihikoAbe/eapki/keyring"
+)
+
+// MetadataMode selects how a dump writes keyring.dat and file.inf
+type MetadataMode int
+
+const (
+	// MetadataRaw writes the files as they are stored in the volume
+	MetadataRaw MetadataMode = iota
+	// MetadataSkip doesn't write the files
+	MetadataSkip
+	// MetadataDecode writes file.inf as XML (file.inf.xml), and the metadata
+	// of keyring.dat as JSON (keyring.dat.json)
+	MetadataDecode
+)
+
+// DecodeFileList returns a copy of the volume's file list that is readable by humans.
+// dst_path values are formatted as hash paths, file nodes are annotated with their
+// real path, and key_idx nodes with whether the file is encrypted.
+func (v *Volume) DecodeFileList() (*avsproperty.Node, error) {
+	return decodeNode(v.files, "")
+}
+
+func decodeNode(node *avsproperty.Node, current string) (*avsproperty.Node, error) {
+	decoded, err := avsproperty.NewNode(node.Name().String())
+	if err != nil {
+		return nil, err
+	}
+	for _, attr := range node.Attributes() {
+		if err := decoded.SetAttribute(attr.Key().String(), attr.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	name := node.AttributeValueNodeName(nameNodeName)
+	switch {
+	case node.Name().Equals(dirNodeName):
+		current = path.Join(current, name)
+	case node.Name().Equals(fileNodeName):
+		decoded.SetAttribute("path", path.Join(current, name))
+	case node.Name().Equals(pathNodeName):
+		value := node.Value()
+		if hashPath, err := formatHashPath(node.BinaryValue()); err == nil {
+			value = hashPath
+		}
+		if err := decoded.SetValue(value); err != nil {
+			return nil, err
+		}
+		return decoded, nil
+	case node.Name().Equals(keyNodeName):
+		decoded.SetAttribute("encrypted", strconv.FormatBool(node.UintValue() != 0))
+	}
+
+	if value := node.Value(); value != nil {
+		if err := decoded.SetValue(value); err != nil {
+			return nil, err
+		}
+	}
+	for _, child := range node.Children() {
+		c, err := decodeNode(child, current)
+		if err != nil {
+			return nil, err
+		}
+		if err := decoded.AppendChild(c); err != nil {
+			return nil, err
+		}
+	}
+	return decoded, nil
+}
+
+// decodeMetadata returns the decoded copies of keyring.dat and file.inf
+func (v *Volume) decodeMetadata() (keyringInfo []byte, fileList []byte, err error) {
+	info, err := keyring.ReadInfo(bytes.NewReader(v.keyringData))
+	if err != nil {
+		return nil, nil, err
+	}
+	if keyringInfo, err = json.MarshalIndent(info, "", " "); err != nil {
+		return nil, nil, err
+	}
+
+	root, err := v.DecodeFileList()
+	if err != nil {
+		return nil, nil, err
+	}
+	prop := &avsproperty.Property{
+		Settings: avsproperty.PropertySettings{Format: avsproperty.FormatPrettyXML},
+		Root:     root,
+	}
+	buf := &bytes.Buffer{}
+	if err := prop.Write(buf); err != nil {
+		return nil, nil, err
+	}
+	return keyringInfo, buf.Bytes(), nil
+}
@@ -0,0 +1,66 @@
+package drmfs
+
+import (
+	"io"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/YoshihikoAbe/avsproperty"
+)
+
+func TestDecodeFileList(t *testing.T) {
+	v := openTestVolume(t)
+	root, err := v.DecodeFileList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := 0
+	root.Traverse(func(n *avsproperty.Node) error {
+		if !n.Name().Equals(fileNodeName) {
+			return nil
+		}
+		files++
+
+		realPath := n.AttributeValue("path")
+		hashPath := n.SearchChildNodeName(pathNodeName).StringValue()
+		if hashPath != v.obfuscator.Obfuscate(realPath) {
+			t.Errorf("%s: unexpected hash path %s", realPath, hashPath)
+		}
+		key := n.SearchChildNodeName(keyNodeName)
+		if encrypted := key.AttributeValue("encrypted"); encrypted != strconv.FormatBool(key.UintValue() != 0) {
+			t.Errorf("%s: unexpected annotation %s", realPath, encrypted)
+		}
+		return nil
+	}, nil)
+	if files != len(testFiles) {
+		t.Errorf("%d files decoded", files)
+	}
+}
+
+func TestDumpMetadata(t *testing.T) {
+	v := openTestVolume(t)
+
+	for mode, expected := range map[MetadataMode][]string{
+		MetadataRaw:    {"file.inf", "keyring.dat"},
+		MetadataSkip:   {},
+		MetadataDecode: {"file.inf.xml", "keyring.dat.json"},
+	} {
+		ch, _ := v.Dump(&DumpOptions{
+			Metadata: mode,
+			// only select top level files with an extension
+			Filter: &Filter{Include: []string{"*.*"}},
+		})
+		names := []string{}
+		for f := range ch {
+			names = append(names, f.Path)
+			io.Copy(io.Discard, f.Reader)
+			f.Close()
+		}
+		slices.Sort(names)
+		if !slices.Equal(names, expected) {
+			t.Errorf("mode %d: unexpected files %v", mode, names)
+		}
+	}
+}
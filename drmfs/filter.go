@@ -0,0 +1,82 @@
+package drmfs
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// Filter selects files by their real path. A file is selected if it
+// matches at least one of the include criteria (or if none are set),
+// and none of the exclude criteria.
+type Filter struct {
+	// Include and Exclude are doublestar glob patterns, e.g. data/graphic/**
+	Include []string
+	Exclude []string
+
+	IncludeRegexp []*regexp.Regexp
+	ExcludeRegexp []*regexp.Regexp
+
+	// Paths is a set of real paths to include
+	Paths map[string]bool
+}
+
+// Validate checks the syntax of the filter's glob patterns
+func (f *Filter) Validate() error {
+	for _, pattern := range append(f.Include, f.Exclude...) {
+		if !doublestar.ValidatePattern(pattern) {
+			return drmError("invalid pattern: " + pattern)
+		}
+	}
+	return nil
+}
+
+// Match reports whether the file at the specified real path is selected by the filter.
+// A nil Filter selects every file.
+func (f *Filter) Match(name string) bool {
+	if f == nil {
+		return true
+	}
+
+	if len(f.Include) > 0 || len(f.IncludeRegexp) > 0 || f.Paths != nil {
+		if !f.Paths[name] && !matchGlobs(f.Include, name) && !matchRegexps(f.IncludeRegexp, name) {
+			return false
+		}
+	}
+	return !matchGlobs(f.Exclude, name) && !matchRegexps(f.ExcludeRegexp, name)
+}
+
+func matchGlobs(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if doublestar.MatchUnvalidated(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchRegexps(res []*regexp.Regexp, name string) bool {
+	for _, re := range res {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadPathList reads a list of real paths, one per line. Blank lines and lines starting with # are ignored.
+func ReadPathList(rd io.Reader) (map[string]bool, error) {
+	paths := map[string]bool{}
+	scanner := bufio.NewScanner(rd)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths[strings.TrimPrefix(line, "/")] = true
+	}
+	return paths, scanner.Err()
+}
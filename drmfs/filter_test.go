@@ -0,0 +1,36 @@
+package drmfs
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	filter := &Filter{
+		Include:       []string{"data/graphic/**", "prop/*.xml"},
+		Exclude:       []string{"**/*.bak"},
+		ExcludeRegexp: []*regexp.Regexp{regexp.MustCompile(`^prop/secret`)},
+		Paths:         map[string]bool{"readme": true},
+	}
+	if err := filter.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]bool{
+		"data/graphic/a/b.ifs":   true,
+		"data/graphic/a/b.bak":   false,
+		"data/sound/a.wav":       false,
+		"prop/ea3-config.xml":    true,
+		"prop/sub/config.xml":    false,
+		"prop/secret-config.xml": false,
+		"readme":                 true,
+	} {
+		if got := filter.Match(name); got != want {
+			t.Fatalf("%s: got %t, want %t", name, got, want)
+		}
+	}
+
+	if !(*Filter)(nil).Match("anything") {
+		t.Fatal("nil filter must match every file")
+	}
+}
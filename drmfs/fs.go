@@ -0,0 +1,305 @@
+package drmfs
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+	"time"
+)
+
+// FS presents the logical tree recorded in a volume's file list as a
+// read-only fs.FS. Files are decrypted on the fly, and support seeking.
+type FS struct {
+	vol  *Volume
+	root *fsNode
+}
+
+type fsNode struct {
+	name string
+
+	// set for files
+	entry *Entry
+
+	// set for directories
+	children map[string]*fsNode
+	names    []string
+}
+
+func NewFS(v *Volume) *FS {
+	fsys := &FS{
+		vol:  v,
+		root: newDirNode("."),
+	}
+	for e, err := range v.Entries() {
+		if err == nil {
+			fsys.add(e)
+		}
+	}
+	return fsys
+}
+
+func newDirNode(name string) *fsNode {
+	return &fsNode{
+		name:     name,
+		children: map[string]*fsNode{},
+	}
+}
+
+func (fsys *FS) add(e Entry) {
+	elems := strings.Split(e.Path, "/")
+	dir := fsys.root
+	for _, elem := range elems[:len(elems)-1] {
+		sub, ok := dir.children[elem]
+		if !ok || sub.children == nil {
+			sub = newDirNode(elem)
+			dir.add(sub)
+		}
+		dir = sub
+	}
+	dir.add(&fsNode{
+		name:  elems[len(elems)-1],
+		entry: &e,
+	})
+}
+
+func (dir *fsNode) add(node *fsNode) {
+	if _, ok := dir.children[node.name]; !ok {
+		i, _ := slices.BinarySearch(dir.names, node.name)
+		dir.names = slices.Insert(dir.names, i, node.name)
+	}
+	dir.children[node.name] = node
+}
+
+func (fsys *FS) lookup(name string) (*fsNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, fs.ErrInvalid
+	}
+
+	node := fsys.root
+	if name == "." {
+		return node, nil
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if node.children == nil {
+			return nil, fs.ErrNotExist
+		}
+		next, ok := node.children[elem]
+		if !ok {
+			return nil, fs.ErrNotExist
+		}
+		node = next
+	}
+	return node, nil
+}
+
+func (fsys *FS) Open(name string) (fs.File, error) {
+	node, err := fsys.lookup(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if node.children != nil {
+		return &fsDir{fsys: fsys, node: node}, nil
+	}
+
+	f, err := fsys.openFile(node)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return f, nil
+}
+
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	node, err := fsys.lookup(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	info, err := fsys.stat(node)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return info, nil
+}
+
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	node, err := fsys.lookup(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	if node.children == nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: drmError("not a directory")}
+	}
+	return fsys.readDir(node), nil
+}
+
+func (fsys *FS) readDir(node *fsNode) []fs.DirEntry {
+	entries := make([]fs.DirEntry, len(node.names))
+	for i, name := range node.names {
+		entries[i] = &fsDirEntry{fsys: fsys, node: node.children[name]}
+	}
+	return entries
+}
+
+func (fsys *FS) stat(node *fsNode) (*fileInfo, error) {
+	if node.children != nil {
+		return &fileInfo{name: node.name, mode: fs.ModeDir | 0555}, nil
+	}
+
+	info, err := fsys.vol.Stat(*node.entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileInfo{
+		name:    node.name,
+		size:    node.entry.contentSize(info.Size()),
+		mode:    0444,
+		modTime: info.ModTime(),
+	}, nil
+}
+
+func (fsys *FS) openFile(node *fsNode) (*fsFile, error) {
+	key := node.entry.Key
+	layer := fsys.vol.layer(*node.entry)
+	f, err := layer.src.Open(node.entry.BackingPath())
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	ra, err := readerAt(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	var rd *io.SectionReader
+	if key != 0 {
+		if rd, err = layer.keyring.MakeSectionReader(ra, info.Size(), key); err != nil {
+			f.Close()
+			return nil, err
+		}
+	} else {
+		rd = io.NewSectionReader(ra, 0, info.Size())
+	}
+
+	return &fsFile{
+		SectionReader: rd,
+		f:             f,
+		info: &fileInfo{
+			name:    node.name,
+			size:    rd.Size(),
+			mode:    0444,
+			modTime: info.ModTime(),
+		},
+	}, nil
+}
+
+// readerAt returns f if it supports random access, and its contents otherwise
+func readerAt(f fs.File) (io.ReaderAt, error) {
+	if ra, ok := f.(io.ReaderAt); ok {
+		return ra, nil
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(b), nil
+}
+
+type fsFile struct {
+	*io.SectionReader
+	f    fs.File
+	info *fileInfo
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *fsFile) Close() error {
+	return f.f.Close()
+}
+
+type fsDir struct {
+	fsys    *FS
+	node    *fsNode
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) {
+	return d.fsys.stat(d.node)
+}
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.node.name, Err: drmError("is a directory")}
+}
+
+func (d *fsDir) Close() error {
+	return nil
+}
+
+func (d *fsDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	if d.entries == nil {
+		d.entries = d.fsys.readDir(d.node)
+	}
+
+	n := len(d.entries) - d.offset
+	if count > 0 && n > count {
+		n = count
+	}
+	if n == 0 && count > 0 {
+		return nil, io.EOF
+	}
+	entries := d.entries[d.offset : d.offset+n]
+	d.offset += n
+	return entries, nil
+}
+
+type fsDirEntry struct {
+	fsys *FS
+	node *fsNode
+}
+
+func (e *fsDirEntry) Name() string {
+	return e.node.name
+}
+
+func (e *fsDirEntry) IsDir() bool {
+	return e.node.children != nil
+}
+
+func (e *fsDirEntry) Type() fs.FileMode {
+	if e.IsDir() {
+		return fs.ModeDir
+	}
+	return 0
+}
+
+func (e *fsDirEntry) Info() (fs.FileInfo, error) {
+	info, err := e.fsys.stat(e.node)
+	if err != nil {
+		// don't let a single missing backing file break directory listings
+		return &fileInfo{name: e.node.name, mode: 0444}, nil
+	}
+	return info, nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (info *fileInfo) Name() string       { return info.name }
+func (info *fileInfo) Size() int64        { return info.size }
+func (info *fileInfo) Mode() fs.FileMode  { return info.mode }
+func (info *fileInfo) ModTime() time.Time { return info.modTime }
+func (info *fileInfo) IsDir() bool        { return info.mode.IsDir() }
+func (info *fileInfo) Sys() any           { return nil }
@@ -0,0 +1,84 @@
+package drmfs
+
+import (
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+
+	"github.com/YoshihikoAbe/avsproperty"
+)
+
+// GenerateList hashes every file under root, and returns a file integrity
+// list in the schema of prop/filepath.xml. Only the Workers, Progress and
+// Ignore options are used.
+func GenerateList(root string, opts *CheckOptions) (*avsproperty.Node, error) {
+	return generateList(os.DirFS(root), opts)
+}
+
+func generateList(fsys fs.FS, opts *CheckOptions) (*avsproperty.Node, error) {
+	if opts == nil {
+		opts = &CheckOptions{}
+	}
+
+	names := []string{}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() && !opts.ignored(name) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(names)
+
+	entries := make([]listEntry, len(names))
+	errs := make([]error, len(names))
+	opts.hashEach(len(names), func(i int, hash hash.Hash) {
+		entries[i], errs[i] = hashFile(fsys, names[i], hash)
+	})
+
+	schema := listSchemas[0]
+	list, _ := avsproperty.NewNode("list")
+	for i, entry := range entries {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+
+		node, _ := list.NewNode(fileNodeName.String())
+		if _, err := node.NewNodeWithValue(schema.path, entry.path); err != nil {
+			return nil, err
+		}
+		if _, err := node.NewNodeWithValue(schema.md5, avsproperty.BinValue(entry.md5)); err != nil {
+			return nil, err
+		}
+		if _, err := node.NewNodeWithValue(schema.size, uint64(entry.size)); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+func hashFile(fsys fs.FS, name string, hash hash.Hash) (listEntry, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return listEntry{}, err
+	}
+	defer f.Close()
+
+	hash.Reset()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return listEntry{}, err
+	}
+	return listEntry{
+		path: "/" + name,
+		md5:  hash.Sum(nil),
+		size: size,
+	}, nil
+}
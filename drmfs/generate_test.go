@@ -0,0 +1,36 @@
+package drmfs
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestGenerateList(t *testing.T) {
+	fsys := fstest.MapFS{
+		"data/a.txt":     {Data: []byte("hello world")},
+		"data/sub/b.bin": {Data: []byte{0, 1, 2, 3}},
+		"readme":         {Data: []byte{}},
+		"log/run.log":    {Data: []byte("varies")},
+	}
+	opts := &CheckOptions{Ignore: []string{"log/**"}}
+
+	list, err := generateList(fsys, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readList(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].path != "/data/a.txt" || entries[0].size != 11 {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+
+	result, err := checkContents(entries, fsys, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.OK() || result.TotalExtra != 0 || result.TotalFiles != 3 {
+		t.Errorf("generated list doesn't verify: %+v", result)
+	}
+}
@@ -0,0 +1,150 @@
+package drmfs
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/YoshihikoAbe/avsproperty"
+)
+
+// Incremental skips files whose copy in a previous dump is known to be up to date
+type Incremental struct {
+	dest    string
+	files   map[string]listEntry
+	records map[string]ManifestRecord
+}
+
+// NewIncremental compares files against the dump at dest, using list
+// (usually prop/filepath.xml of the filesystem being dumped) as the
+// source of truth for their size and MD5
+func NewIncremental(dest string, list *avsproperty.Node) (*Incremental, error) {
+	files, err := listFiles(list)
+	if err != nil {
+		return nil, err
+	}
+	return &Incremental{
+		dest:  dest,
+		files: files,
+	}, nil
+}
+
+// NewManifestIncremental compares files against the dump at dest, using the
+// manifest of that dump. A file is skipped if its backing file has the same
+// obfuscated path, key and size as when the manifest was written, and its
+// copy in dest still matches the recorded SHA-256.
+func NewManifestIncremental(dest string, records []ManifestRecord) *Incremental {
+	inc := &Incremental{
+		dest:    dest,
+		records: make(map[string]ManifestRecord, len(records)),
+	}
+	for _, rec := range records {
+		if (rec.Status == StatusOK || rec.Status == StatusUnchanged) && rec.SHA256 != "" {
+			inc.records[rec.Path] = rec
+		}
+	}
+	return inc
+}
+
+// unchanged reports whether e can be skipped, and if so, returns a record describing its copy in dest
+func (inc *Incremental) unchanged(v *Volume, e Entry) (ManifestRecord, bool) {
+	rec := e.record(StatusUnchanged)
+	info, err := v.Stat(e)
+	if err != nil {
+		return rec, false
+	}
+	rec.EncryptedSize = info.Size()
+
+	var (
+		size      int64
+		md5Sum    []byte
+		sha256Sum string
+	)
+	if entry, ok := inc.files[e.Path]; ok {
+		size, md5Sum = entry.size, entry.md5
+	} else if prev, ok := inc.records[e.Path]; ok &&
+		prev.HashPath == rec.HashPath && prev.Key == rec.Key && prev.EncryptedSize == rec.EncryptedSize {
+		size, sha256Sum = prev.Size, prev.SHA256
+	} else {
+		return rec, false
+	}
+
+	f, err := os.Open(path.Join(inc.dest, e.Path))
+	if err != nil {
+		return rec, false
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil || info.Size() != size {
+		return rec, false
+	}
+
+	md5Hash, sha256Hash := md5.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(md5Hash, sha256Hash), f); err != nil {
+		return rec, false
+	}
+	rec.Size = size
+	rec.SHA256 = hex.EncodeToString(sha256Hash.Sum(nil))
+	if md5Sum != nil {
+		return rec, bytes.Equal(md5Hash.Sum(nil), md5Sum)
+	}
+	return rec, rec.SHA256 == sha256Sum
+}
+
+// Journal records the files that have been completely dumped, so that an
+// interrupted dump can be resumed
+type Journal struct {
+	mu   sync.Mutex
+	f    *os.File
+	done map[string]bool
+}
+
+// OpenJournal opens the journal with the specified name, creating it if it doesn't exist
+func OpenJournal(name string) (*Journal, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal{
+		f:    f,
+		done: map[string]bool{},
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			j.done[line] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// Contains reports whether the file at the specified real path has been recorded
+func (j *Journal) Contains(name string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.done[name]
+}
+
+// Add records the file at the specified real path
+func (j *Journal) Add(name string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.done[name] = true
+	_, err := j.f.WriteString(name + "\n")
+	return err
+}
+
+func (j *Journal) Close() error {
+	return j.f.Close()
+}
@@ -0,0 +1,131 @@
+package drmfs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/YoshihikoAbe/avsproperty"
+	"github.com/YoshihikoAbe/fsdump"
+)
+
+// dumpTo dumps v to dest
+func dumpTo(t *testing.T, v *Volume, dest string, opts *DumpOptions) *DumpReport {
+	t.Helper()
+
+	opts.Metadata = MetadataSkip
+	ch, report := v.Dump(opts)
+	dumper := fsdump.Dumper{
+		Src:  &fsdump.ChannelFileSource{Chan: ch},
+		Dest: dest,
+	}
+	dumper.Run()
+	return report
+}
+
+// tamper modifies the copy of readme in dest
+func tamper(t *testing.T, dest string) {
+	t.Helper()
+
+	if err := os.WriteFile(filepath.Join(dest, "readme"), []byte("top lever"), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIncremental(t *testing.T) {
+	v := openTestVolume(t)
+	dest := t.TempDir()
+	if report := dumpTo(t, v, dest, &DumpOptions{}); report.Failed() {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	tamper(t, dest)
+
+	prop := &avsproperty.Property{}
+	if err := prop.Read(strings.NewReader(testFileList(testFiles))); err != nil {
+		t.Fatal(err)
+	}
+	inc, err := NewIncremental(dest, prop.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := dumpTo(t, v, dest, &DumpOptions{Incremental: inc})
+	if !slices.Equal(report.OK, []string{"readme"}) || report.TotalUnchanged != len(testFiles)-1 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dest, "readme")); string(b) != "top level" {
+		t.Error("changed file wasn't dumped again")
+	}
+}
+
+func TestManifestIncremental(t *testing.T) {
+	v := openTestVolume(t)
+	dest := t.TempDir()
+	buf := &bytes.Buffer{}
+	manifest := NewManifest(buf, ManifestJSONL)
+	dumpTo(t, v, dest, &DumpOptions{Manifest: manifest})
+	manifest.Flush()
+	tamper(t, dest)
+
+	records, err := ReadManifest(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, rec := range records {
+		switch rec.Path {
+		case "data/a.txt":
+			// failed files can't be skipped
+			records[i].Status = StatusDecryptFailed
+		case "data/sub/b.bin":
+			records[i].SHA256 = ""
+		}
+	}
+	inc := NewManifestIncremental(dest, records)
+	if len(inc.records) != len(testFiles)-2 {
+		t.Fatalf("%d records kept", len(inc.records))
+	}
+
+	report := dumpTo(t, v, dest, &DumpOptions{Incremental: inc})
+	slices.Sort(report.OK)
+	if !slices.Equal(report.OK, []string{"data/a.txt", "data/sub/b.bin", "readme"}) ||
+		!slices.Equal(report.Unchanged, []string{"prop/plain.xml"}) {
+		t.Errorf("unexpected report: %+v", report)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "journal")
+	// written by a dump that was interrupted
+	if err := os.WriteFile(name, []byte("data/a.txt\n\nreadme\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	journal, err := OpenJournal(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !journal.Contains("data/a.txt") || !journal.Contains("readme") || journal.Contains("") {
+		t.Fatal("journal wasn't read")
+	}
+
+	report := dumpTo(t, openTestVolume(t), t.TempDir(), &DumpOptions{Journal: journal})
+	journal.Close()
+	slices.Sort(report.Unchanged)
+	if !slices.Equal(report.Unchanged, []string{"data/a.txt", "readme"}) || report.TotalOK != len(testFiles)-2 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	// every file is recorded once the dump is resumed
+	if journal, err = OpenJournal(name); err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	for _, f := range testFiles {
+		if !journal.Contains(f.path) {
+			t.Errorf("%s wasn't recorded", f.path)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package drmfs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// Status describes the outcome of dumping a file
+type Status string
+
+const (
+	StatusOK            Status = "ok"
+	StatusUnchanged     Status = "unchanged"
+	StatusMissing       Status = "missing"
+	StatusInvalidNode   Status = "invalid_node"
+	StatusDecryptFailed Status = "decrypt_failed"
+	StatusWriteFailed   Status = "write_failed"
+	// StatusBroken is set for files that were dumped, but whose contents
+	// don't match the file integrity list
+	StatusBroken Status = "broken"
+)
+
+// ManifestRecord describes the provenance of a dumped file
+type ManifestRecord struct {
+	Path          string `json:"path"`
+	HashPath      string `json:"dst_path,omitempty"`
+	Key           uint32 `json:"key_idx"`
+	EncryptedSize int64  `json:"encrypted_size"`
+	Size          int64  `json:"size"`
+	// SHA256 is the hex encoded SHA-256 of the decrypted file
+	SHA256 string `json:"sha256,omitempty"`
+	Status Status `json:"status"`
+	// Layer is the index of the volume the file was dumped from in an overlay
+	Layer int `json:"layer,omitempty"`
+}
+
+// record returns a manifest record describing e
+func (e Entry) record(status Status) ManifestRecord {
+	return ManifestRecord{
+		Path:     e.Path,
+		HashPath: e.HashPath,
+		Key:      e.Key,
+		Status:   status,
+		Layer:    e.Layer,
+	}
+}
+
+var manifestHeader = []string{"path", "dst_path", "key_idx", "encrypted_size", "size", "sha256", "status", "layer"}
+
+func (rec *ManifestRecord) csv() []string {
+	return []string{
+		rec.Path,
+		rec.HashPath,
+		strconv.FormatUint(uint64(rec.Key), 10),
+		strconv.FormatInt(rec.EncryptedSize, 10),
+		strconv.FormatInt(rec.Size, 10),
+		rec.SHA256,
+		string(rec.Status),
+		strconv.Itoa(rec.Layer),
+	}
+}
+
+func (rec *ManifestRecord) parseCSV(fields []string) (err error) {
+	// manifests written before overlays were supported have no layer column
+	if len(fields) != len(manifestHeader) && len(fields) != len(manifestHeader)-1 {
+		return drmError("invalid number of fields in manifest")
+	}
+
+	rec.Path = fields[0]
+	rec.HashPath = fields[1]
+	key, err := strconv.ParseUint(fields[2], 10, 32)
+	if err != nil {
+		return err
+	}
+	rec.Key = uint32(key)
+	if rec.EncryptedSize, err = strconv.ParseInt(fields[3], 10, 64); err != nil {
+		return err
+	}
+	if rec.Size, err = strconv.ParseInt(fields[4], 10, 64); err != nil {
+		return err
+	}
+	rec.SHA256 = fields[5]
+	rec.Status = Status(fields[6])
+	if len(fields) > 7 {
+		if rec.Layer, err = strconv.Atoi(fields[7]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type ManifestFormat int
+
+const (
+	// JSON Lines
+	ManifestJSONL ManifestFormat = iota
+	ManifestCSV
+)
+
+// Manifest writes manifest records. It is safe for concurrent use.
+type Manifest struct {
+	mu     sync.Mutex
+	wr     io.Writer
+	csv    *csv.Writer
+	format ManifestFormat
+}
+
+func NewManifest(wr io.Writer, format ManifestFormat) *Manifest {
+	m := &Manifest{
+		wr:     wr,
+		format: format,
+	}
+	if format == ManifestCSV {
+		m.csv = csv.NewWriter(wr)
+		m.csv.Write(manifestHeader)
+	}
+	return m
+}
+
+func (m *Manifest) Write(rec ManifestRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.format == ManifestCSV {
+		return m.csv.Write(rec.csv())
+	}
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = m.wr.Write(append(b, '\n'))
+	return err
+}
+
+// Flush writes any buffered records to the underlying writer
+func (m *Manifest) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.csv != nil {
+		m.csv.Flush()
+		return m.csv.Error()
+	}
+	return nil
+}
+
+// ReadManifest reads a manifest in either of the formats written by Manifest
+func ReadManifest(rd io.Reader) ([]ManifestRecord, error) {
+	br := bufio.NewReader(rd)
+	magic, err := br.Peek(1)
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	records := []ManifestRecord{}
+	if magic[0] == '{' {
+		scanner := bufio.NewScanner(br)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			rec := ManifestRecord{}
+			if err := json.Unmarshal(line, &rec); err != nil {
+				return nil, err
+			}
+			records = append(records, rec)
+		}
+		return records, scanner.Err()
+	}
+
+	cr := csv.NewReader(br)
+	if _, err := cr.Read(); err != nil {
+		return nil, err
+	}
+	for {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return nil, err
+		}
+		rec := ManifestRecord{}
+		if err := rec.parseCSV(fields); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+}
@@ -0,0 +1,59 @@
+package drmfs
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var testRecords = []ManifestRecord{
+	{"keyring.dat", "", 0, 668, 668, strings.Repeat("0a", 32), StatusOK, 1},
+	{"data/a.txt", "c/b/4/e0907fc12bc293c3ccc29f70bb8296f254d4d", 1, 41, 11, strings.Repeat("cf", 32), StatusUnchanged, 0},
+	{"data/b, \"quoted\".bin", "9/3/c/671e1bb8bfe0613188ff228f60520a8f9426c", 4294967295, 1 << 40, 1<<40 - 30, "", StatusBroken, 2},
+	{"missing.txt", "f/d/5/be7173456ecc919783ed9bc7248712d7a04dc", 2, 0, 0, "", StatusMissing, 0},
+}
+
+func TestManifest(t *testing.T) {
+	for _, format := range []ManifestFormat{ManifestJSONL, ManifestCSV} {
+		buf := &bytes.Buffer{}
+		m := NewManifest(buf, format)
+		for _, rec := range testRecords {
+			if err := m.Write(rec); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := m.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		records, err := ReadManifest(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(records, testRecords) {
+			t.Errorf("%d: records differ: %+v", format, records)
+		}
+	}
+
+	if records, err := ReadManifest(strings.NewReader("")); err != nil || len(records) != 0 {
+		t.Error("empty manifest wasn't read")
+	}
+	if _, err := ReadManifest(strings.NewReader("path,dst_path\na,b\n")); err == nil {
+		t.Error("manifest with missing columns was read")
+	}
+}
+
+func TestManifestCSVWithoutLayer(t *testing.T) {
+	// written before overlays were supported
+	const old = `path,dst_path,key_idx,encrypted_size,size,sha256,status
+data/a.txt,c/b/4/e0907fc12bc293c3ccc29f70bb8296f254d4d,1,41,11,` + "cfcfcfcfcfcfcfcfcfcfcfcfcfcfcfcfcfcfcfcfcfcfcfcfcfcfcfcfcfcfcfcf" + `,unchanged
+`
+	records, err := ReadManifest(strings.NewReader(old))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(records, testRecords[1:2]) {
+		t.Errorf("unexpected records: %+v", records)
+	}
+}
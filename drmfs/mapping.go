@@ -0,0 +1,79 @@
+package drmfs
+
+import (
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+// MappedFile associates a file in a volume's root directory with its real path
+type MappedFile struct {
+	BackingPath string `json:"backing_path"`
+	Path        string `json:"path"`
+}
+
+// PathMap relates the files stored in a volume's root directory to the file list
+type PathMap struct {
+	// Mapped contains every referenced file that exists, sorted by backing path
+	Mapped []MappedFile `json:"mapped"`
+	// Orphaned contains the backing paths of files that are not referenced
+	Orphaned []string `json:"orphaned"`
+	// Missing contains the real paths of referenced files that don't exist
+	Missing []string `json:"missing"`
+}
+
+// Map computes the real path of every file in the volume's root directory
+func (v *Volume) Map() (*PathMap, error) {
+	if v.layers != nil {
+		return nil, drmError("cannot map the files of an overlay")
+	}
+
+	refs := map[string]string{
+		v.obfuscator.Obfuscate("keyring.dat"): "keyring.dat",
+		v.obfuscator.Obfuscate("file.inf"):    "file.inf",
+	}
+	for e, err := range v.Entries() {
+		if err == nil {
+			refs[e.BackingPath()] = e.Path
+		}
+	}
+
+	pm := &PathMap{
+		Mapped:   []MappedFile{},
+		Orphaned: []string{},
+		Missing:  []string{},
+	}
+	found := map[string]bool{}
+	err := fs.WalkDir(v.src, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		if realPath, ok := refs[name]; ok {
+			pm.Mapped = append(pm.Mapped, MappedFile{name, realPath})
+			found[name] = true
+		} else {
+			pm.Orphaned = append(pm.Orphaned, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for backingPath, realPath := range refs {
+		if !found[backingPath] {
+			if _, err := fs.Stat(v.src, backingPath); err != nil {
+				pm.Missing = append(pm.Missing, realPath)
+			}
+		}
+	}
+	slices.Sort(pm.Missing)
+	slices.SortFunc(pm.Mapped, func(a, b MappedFile) int {
+		return strings.Compare(a.BackingPath, b.BackingPath)
+	})
+	return pm, nil
+}
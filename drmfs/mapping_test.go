@@ -0,0 +1,43 @@
+package drmfs
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMap(t *testing.T) {
+	fix := newTestFixture()
+	fix.build(t, testFiles, nil)
+	fsys := fix.mapFS()
+	delete(fsys, fix.obfuscator.Obfuscate("readme"))
+	fsys["0/0/0/stray"] = &fstest.MapFile{Data: []byte("unreferenced")}
+
+	v, err := OpenFS(fsys, fix.ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, err := v.Map()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(pm.Missing, []string{"readme"}) {
+		t.Error("missing:", pm.Missing)
+	}
+	if !slices.Equal(pm.Orphaned, []string{"0/0/0/stray"}) {
+		t.Error("orphaned:", pm.Orphaned)
+	}
+
+	expected := []MappedFile{}
+	for _, name := range []string{"keyring.dat", "file.inf", "data/a.txt", "data/sub/b.bin", "prop/plain.xml"} {
+		expected = append(expected, MappedFile{fix.obfuscator.Obfuscate(name), name})
+	}
+	slices.SortFunc(expected, func(a, b MappedFile) int {
+		return strings.Compare(a.BackingPath, b.BackingPath)
+	})
+	if !slices.Equal(pm.Mapped, expected) {
+		t.Errorf("mapped: %+v", pm.Mapped)
+	}
+}
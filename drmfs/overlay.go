@@ -0,0 +1,56 @@
+package drmfs
+
+import "iter"
+
+// Overlay merges volumes, such as a base game and its updates, into one
+// logical tree. Files of later volumes override the files at the same real
+// path in earlier volumes, and entries record the index of the volume they
+// belong to in Layer. The keyring and file list of an overlay, which are
+// dumped as its metadata, are those of the last volume.
+func Overlay(vols ...*Volume) *Volume {
+	if len(vols) == 0 {
+		panic("eapki/drmfs: overlay of no volumes")
+	}
+	top := vols[len(vols)-1]
+	return &Volume{
+		obfuscator:   top.obfuscator,
+		keyring:      top.keyring,
+		src:          top.src,
+		files:        top.files,
+		keyringData:  top.keyringData,
+		fileListData: top.fileListData,
+		layers:       vols,
+	}
+}
+
+// overlayEntries yields the merged entries of the layers, in the order their
+// real paths first appear. Invalid nodes of every layer are yielded.
+func (v *Volume) overlayEntries() iter.Seq2[Entry, error] {
+	return func(yield func(Entry, error) bool) {
+		type merged struct {
+			e   Entry
+			err error
+		}
+		entries := []merged{}
+		index := map[string]int{}
+		for i, layer := range v.layers {
+			for e, err := range layer.Entries() {
+				e.Layer = i
+				if err != nil {
+					entries = append(entries, merged{e, err})
+				} else if j, ok := index[e.Path]; ok {
+					entries[j].e = e
+				} else {
+					index[e.Path] = len(entries)
+					entries = append(entries, merged{e, nil})
+				}
+			}
+		}
+
+		for _, m := range entries {
+			if !yield(m.e, m.err) {
+				return
+			}
+		}
+	}
+}
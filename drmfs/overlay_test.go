@@ -0,0 +1,56 @@
+package drmfs
+
+import (
+	"io"
+	"io/fs"
+	"testing"
+)
+
+func TestOverlay(t *testing.T) {
+	base := openTestVolume(t)
+
+	// updates have their own contents code and keyring
+	fix := newTestFixture()
+	fix.ks.Code = "UPDT"
+	fix.obfuscator.Init(fix.ks.Code)
+	fix.build(t, []testFile{
+		{"readme", "updated", 2},
+		{"data/c.txt", "added", 1},
+	}, nil)
+	update, err := Open(fix.writeDir(t), fix.ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := Overlay(base, update)
+
+	layers := map[string]int{}
+	for e, err := range v.Entries() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		layers[e.Path] = e.Layer
+	}
+	if len(layers) != len(testFiles)+1 || layers["readme"] != 1 || layers["data/c.txt"] != 1 || layers["data/a.txt"] != 0 {
+		t.Fatalf("unexpected layers: %v", layers)
+	}
+
+	fsys := NewFS(v)
+	for name, expected := range map[string]string{
+		"readme":     "updated",
+		"data/c.txt": "added",
+		"data/a.txt": "hello world",
+	} {
+		if b, err := fs.ReadFile(fsys, name); err != nil || string(b) != expected {
+			t.Errorf("%s: invalid contents", name)
+		}
+	}
+
+	ch, report := v.Dump(&DumpOptions{Metadata: MetadataSkip})
+	for f := range ch {
+		io.Copy(io.Discard, f.Reader)
+		f.Close()
+	}
+	if report.Failed() || report.Layers["readme"] != 1 || report.Layers["prop/plain.xml"] != 0 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+}
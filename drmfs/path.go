@@ -3,28 +3,31 @@ package drmfs
 import (
 	"crypto/hmac"
 	"crypto/sha1"
-	"hash"
 	"strconv"
 )
 
+// PathObfuscator is safe for concurrent use
 type PathObfuscator struct {
-	hash hash.Hash
+	key []byte
 }
 
 func (po *PathObfuscator) Init(contentsCode string) {
 	key := sha1.Sum([]byte(contentsCode + "test"))
-	po.hash = hmac.New(sha1.New, key[:])
+	po.key = key[:]
 }
 
 func (po PathObfuscator) Obfuscate(path string) string {
-	po.hash.Write([]byte(path))
-	sum := po.hash.Sum(nil)
-	po.hash.Reset()
-
-	out, _ := formatHashPath(sum)
+	out, _ := formatHashPath(po.Sum(path))
 	return out
 }
 
+// Sum returns the hash of path, as stored in the dst_path nodes of a file list
+func (po PathObfuscator) Sum(path string) []byte {
+	mac := hmac.New(sha1.New, po.key)
+	mac.Write([]byte(path))
+	return mac.Sum(nil)
+}
+
 func formatHashPath(b []byte) (string, error) {
 	const tbl = "0123456789abcdef"
 
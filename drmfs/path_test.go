@@ -0,0 +1,33 @@
+package drmfs
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestPathObfuscator(t *testing.T) {
+	const want = "a/2/d/a4f84da1bac86a88f089e42039950868ed43a"
+
+	po := PathObfuscator{}
+	po.Init("TEST")
+
+	wg := sync.WaitGroup{}
+	errs := make(chan string, 8)
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 1000 {
+				if got := po.Obfuscate("readme"); got != want {
+					errs <- got
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for got := range errs {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
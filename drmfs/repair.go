@@ -0,0 +1,70 @@
+package drmfs
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/YoshihikoAbe/avsproperty"
+	"github.com/YoshihikoAbe/eapki/dedup"
+	"github.com/YoshihikoAbe/fsdump"
+)
+
+// RepairResult describes the outcome of a repair
+type RepairResult struct {
+	// Before is the result of checking the dump before it was repaired
+	Before *CheckResult
+	// Dump is the report of the files that were dumped again, nil if none were
+	Dump *DumpReport
+	// After is the result of checking only the files that were dumped again.
+	// Files that are still broken are damaged at the source. nil if none were dumped.
+	After *CheckResult
+}
+
+// OK reports whether every file of the dump is intact after the repair
+func (result *RepairResult) OK() bool {
+	if result.After == nil {
+		return result.Before.OK()
+	}
+	return result.After.OK()
+}
+
+// Repair checks the dump at dest against the file integrity list, and dumps the
+// files that are broken or missing again. The files that were dumped again are
+// then checked once more. opts.Workers is also used as the number of workers of
+// the dump.
+func (v *Volume) Repair(list *avsproperty.Node, dest string, opts *CheckOptions) (*RepairResult, error) {
+	if opts == nil {
+		opts = &CheckOptions{}
+	}
+	before, err := CheckContents(list, dest, opts)
+	if err != nil {
+		return nil, err
+	}
+	result := &RepairResult{Before: before}
+
+	damaged := map[string]bool{}
+	for _, name := range slices.Concat(before.Broken, before.Missing) {
+		damaged[strings.TrimPrefix(name, "/")] = true
+	}
+	if len(damaged) == 0 {
+		return result, nil
+	}
+
+	filter := &Filter{Paths: damaged}
+	ch, report := v.Dump(&DumpOptions{Filter: filter})
+	dumper := fsdump.Dumper{
+		Src:        dedup.BreakLinks(&fsdump.ChannelFileSource{Chan: ch}, dest),
+		Dest:       dest,
+		NumWorkers: opts.Workers,
+	}
+	dumper.Run()
+	result.Dump = report
+
+	// only verify the files that were dumped again
+	withFilter := *opts
+	withFilter.Filter = filter
+	if result.After, err = CheckContents(list, dest, &withFilter); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,45 @@
+package drmfs
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/YoshihikoAbe/avsproperty"
+)
+
+func TestRepair(t *testing.T) {
+	v := openTestVolume(t)
+	dest := t.TempDir()
+	dumpTo(t, v, dest, &DumpOptions{})
+	tamper(t, dest)
+
+	prop := &avsproperty.Property{}
+	if err := prop.Read(strings.NewReader(testFileList(testFiles))); err != nil {
+		t.Fatal(err)
+	}
+	result, err := v.Repair(prop.Root, dest, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(result.Before.Broken, []string{"/readme"}) || result.Before.TotalMissing != 0 {
+		t.Errorf("unexpected check before repair: %+v", result.Before)
+	}
+	if !slices.Equal(result.Dump.OK, []string{"readme"}) || result.Dump.Failed() {
+		t.Errorf("unexpected report: %+v", result.Dump)
+	}
+	if !result.OK() || result.After.TotalFiles != 1 {
+		t.Errorf("unexpected check after repair: %+v", result.After)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dest, "readme")); string(b) != "top level" {
+		t.Error("broken file wasn't repaired")
+	}
+
+	// nothing is dumped once the dump is intact
+	if result, err = v.Repair(prop.Root, dest, nil); err != nil || !result.OK() || result.Dump != nil {
+		t.Errorf("unexpected result of repairing an intact dump: %+v", result)
+	}
+}
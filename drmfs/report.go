@@ -0,0 +1,136 @@
+package drmfs
+
+import (
+	"sync"
+	"time"
+)
+
+// DumpReport summarizes the outcome of a dump. It is complete once the
+// channel returned by Dump has been closed, and every file received from
+// it has been closed.
+type DumpReport struct {
+	mu sync.Mutex
+
+	Time time.Time `json:"time"`
+
+	OK            []string `json:"ok"`
+	Unchanged     []string `json:"unchanged"`
+	Missing       []string `json:"missing"`
+	DecryptFailed []string `json:"decrypt_failed"`
+	InvalidNode   []string `json:"invalid_node"`
+	WriteFailed   []string `json:"write_failed"`
+	Broken        []string `json:"broken"`
+
+	TotalOK            int `json:"total_ok"`
+	TotalUnchanged     int `json:"total_unchanged"`
+	TotalMissing       int `json:"total_missing"`
+	TotalDecryptFailed int `json:"total_decrypt_failed"`
+	TotalInvalidNode   int `json:"total_invalid_node"`
+	TotalWriteFailed   int `json:"total_write_failed"`
+	TotalBroken        int `json:"total_broken"`
+	TotalFiles         int `json:"total_files"`
+
+	// Verified lists the files that were dumped, and whose contents match
+	// the file integrity list. It is only set if the dump was verified.
+	Verified      []string `json:"verified,omitempty"`
+	TotalVerified int      `json:"total_verified,omitempty"`
+
+	// Converted lists the binary property documents that were written as XML
+	Converted      []string `json:"converted,omitempty"`
+	TotalConverted int      `json:"total_converted,omitempty"`
+
+	// Canceled is set if the dump was stopped before every file was sent,
+	// either because its context was canceled or by strict mode
+	Canceled bool `json:"canceled"`
+
+	// Layers maps the real path of each file of an overlay to the index of
+	// the volume it was dumped from
+	Layers map[string]int `json:"layers,omitempty"`
+}
+
+// DumpProgress describes the progress of a dump. Sizes are of decrypted contents.
+type DumpProgress struct {
+	Files      int   `json:"files"`
+	TotalFiles int   `json:"total_files"`
+	Bytes      int64 `json:"bytes"`
+	TotalBytes int64 `json:"total_bytes"`
+}
+
+func newDumpReport() *DumpReport {
+	return &DumpReport{
+		Time:          time.Now(),
+		OK:            []string{},
+		Unchanged:     []string{},
+		Missing:       []string{},
+		DecryptFailed: []string{},
+		InvalidNode:   []string{},
+		WriteFailed:   []string{},
+		Broken:        []string{},
+	}
+}
+
+func (r *DumpReport) add(name string, status Status) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.TotalFiles++
+	switch status {
+	case StatusOK:
+		r.OK = append(r.OK, name)
+		r.TotalOK++
+	case StatusUnchanged:
+		r.Unchanged = append(r.Unchanged, name)
+		r.TotalUnchanged++
+	case StatusMissing:
+		r.Missing = append(r.Missing, name)
+		r.TotalMissing++
+	case StatusDecryptFailed:
+		r.DecryptFailed = append(r.DecryptFailed, name)
+		r.TotalDecryptFailed++
+	case StatusInvalidNode:
+		r.InvalidNode = append(r.InvalidNode, name)
+		r.TotalInvalidNode++
+	case StatusWriteFailed:
+		r.WriteFailed = append(r.WriteFailed, name)
+		r.TotalWriteFailed++
+	case StatusBroken:
+		r.Broken = append(r.Broken, name)
+		r.TotalBroken++
+	}
+}
+
+func (r *DumpReport) verified(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Verified = append(r.Verified, name)
+	r.TotalVerified++
+}
+
+func (r *DumpReport) convert(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Converted = append(r.Converted, name)
+	r.TotalConverted++
+}
+
+func (r *DumpReport) setLayer(name string, layer int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Layers == nil {
+		r.Layers = map[string]int{}
+	}
+	r.Layers[name] = layer
+}
+
+func (r *DumpReport) cancel() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Canceled = true
+}
+
+// Failed reports whether any file could not be dumped or was broken, or the dump was canceled
+func (r *DumpReport) Failed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Canceled || r.TotalMissing+r.TotalDecryptFailed+r.TotalInvalidNode+r.TotalWriteFailed+r.TotalBroken > 0
+}
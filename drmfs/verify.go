@@ -0,0 +1,28 @@
+package drmfs
+
+import "github.com/YoshihikoAbe/avsproperty"
+
+// Verifier checks the decrypted contents of files against a file integrity
+// list while they are being dumped
+type Verifier struct {
+	files map[string]listEntry
+}
+
+// NewVerifier returns a verifier that uses list (usually prop/filepath.xml of
+// the filesystem being dumped) as the source of truth for the size and MD5 of files
+func NewVerifier(list *avsproperty.Node) (*Verifier, error) {
+	files, err := listFiles(list)
+	if err != nil {
+		return nil, err
+	}
+	return &Verifier{files}, nil
+}
+
+// lookup returns the list entry of the file at the specified real path
+func (ver *Verifier) lookup(name string) (listEntry, bool) {
+	if ver == nil {
+		return listEntry{}, false
+	}
+	entry, ok := ver.files[name]
+	return entry, ok
+}
@@ -0,0 +1,105 @@
+package drmfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/YoshihikoAbe/avsproperty"
+	"github.com/YoshihikoAbe/eapki/keyring"
+)
+
+// Volume is a drmfs root whose keyring and file list have been decrypted
+type Volume struct {
+	obfuscator PathObfuscator
+	keyring    *keyring.Keyring
+	src        fs.FS
+	files      *avsproperty.Node
+
+	keyringData  []byte
+	fileListData []byte
+
+	// layers is set if the volume is an overlay
+	layers []*Volume
+}
+
+func Open(root string, ks keyring.KeySource) (*Volume, error) {
+	return OpenFS(os.DirFS(root), ks)
+}
+
+// OpenFS opens the drmfs whose root directory is fsys
+func OpenFS(fsys fs.FS, ks keyring.KeySource) (*Volume, error) {
+	v := &Volume{
+		src: fsys,
+	}
+	v.obfuscator.Init(ks.ContentsCode())
+
+	if err := v.openKeyring(ks); err != nil {
+		return nil, err
+	}
+	if err := v.openFileList(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (v *Volume) Keyring() *keyring.Keyring {
+	return v.keyring
+}
+
+func (v *Volume) openKeyring(ks keyring.KeySource) error {
+	b, err := v.readFile("keyring.dat", -1)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("eapki/drmfs: keyring not found for contents code %s: %w", ks.ContentsCode(), err)
+	} else if err != nil {
+		return err
+	}
+	kr, err := keyring.New(bytes.NewReader(b), ks)
+	if err != nil {
+		return err
+	}
+
+	v.keyring = kr
+	v.keyringData = b
+	return nil
+}
+
+func (v *Volume) openFileList() error {
+	b, err := v.readFile("file.inf", 0)
+	if err != nil {
+		return err
+	}
+
+	prop := &avsproperty.Property{}
+	if err := prop.Read(bytes.NewReader(b)); err != nil {
+		return err
+	}
+	root := prop.Root
+	if root == nil || root.Name().String() != "fileinfo" {
+		return drmError("invalid root node in file list")
+	}
+
+	v.files = root
+	v.fileListData = b
+	return nil
+}
+
+func (v *Volume) readFile(filename string, key int64) ([]byte, error) {
+	f, err := v.src.Open(v.obfuscator.Obfuscate(filename))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rd := io.Reader(f)
+	if key >= 0 {
+		rd, err = v.keyring.MakeReader(rd, uint32(key))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return io.ReadAll(rd)
+}
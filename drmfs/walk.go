@@ -0,0 +1,240 @@
+package drmfs
+
+import (
+	"io"
+	"io/fs"
+	"iter"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/YoshihikoAbe/avsproperty"
+	"github.com/YoshihikoAbe/eapki/keyring"
+)
+
+// Entry is a file recorded in a volume's file list
+type Entry struct {
+	// Path is the real path of the file
+	Path string `json:"path"`
+	// HashPath is the obfuscated path of the file's backing file,
+	// or an empty string if the path is not obfuscated
+	HashPath string `json:"dst_path,omitempty"`
+	// Key is the index of the key the file is encrypted with,
+	// or zero if the file is not encrypted
+	Key uint32 `json:"key_idx"`
+	// Layer is the index of the volume the file belongs to in an overlay
+	Layer int `json:"layer,omitempty"`
+
+	vol *Volume
+}
+
+// BackingPath returns the location of the file's backing file relative to the root of its volume
+func (e Entry) BackingPath() string {
+	if e.HashPath != "" {
+		return e.HashPath
+	}
+	return e.Path
+}
+
+// Open opens the file, and returns a reader that decrypts its contents
+func (e Entry) Open() (io.ReadCloser, error) {
+	if e.vol == nil {
+		return nil, drmError("entry doesn't belong to a volume")
+	}
+	rd, f, err := e.vol.open(e)
+	if err != nil {
+		return nil, err
+	}
+	return &entryReader{rd, f}, nil
+}
+
+type entryReader struct {
+	io.Reader
+	f fs.File
+}
+
+func (rd *entryReader) Close() error {
+	return rd.f.Close()
+}
+
+// InvalidNodeError describes a node in a file list that could not be interpreted
+type InvalidNodeError struct {
+	Path   string
+	Reason string
+}
+
+func (err *InvalidNodeError) Error() string {
+	return "eapki/drmfs: " + err.Path + ": " + err.Reason
+}
+
+// UnsafeNameError describes a node in a file list whose name could refer to a
+// location outside of the directory it belongs to, such as ".." or "/etc"
+type UnsafeNameError struct {
+	Path string
+	Name string
+}
+
+func (err *UnsafeNameError) Error() string {
+	msg := "unsafe name: " + strconv.Quote(err.Name)
+	if err.Path != "" {
+		msg = err.Path + ": " + msg
+	}
+	return drmError(msg).Error()
+}
+
+// safeName reports whether name is a single path element that can be safely
+// joined to a directory on any platform
+func safeName(name string) bool {
+	if name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") {
+		return false
+	}
+	// drive letters
+	return len(name) < 2 || name[1] != ':'
+}
+
+// Entries returns an iterator over the files in the volume's file list, in the
+// order they are recorded. If a node could not be interpreted, the entry is
+// incomplete and the error is an *InvalidNodeError, or an *UnsafeNameError if
+// its name is unsafe. The files of a directory with an unsafe name are skipped.
+func (v *Volume) Entries() iter.Seq2[Entry, error] {
+	if v.layers != nil {
+		return v.overlayEntries()
+	}
+	return func(yield func(Entry, error) bool) {
+		v.entries(v.files, "", yield)
+	}
+}
+
+// WalkFunc is called by Walk for each file in a volume's file list.
+// If a node could not be interpreted, e is incomplete and err is an
+// *InvalidNodeError or *UnsafeNameError. Returning a non-nil error stops the walk.
+type WalkFunc func(e Entry, err error) error
+
+// Walk calls fn for each file in the volume's file list, in the order they are recorded
+func (v *Volume) Walk(fn WalkFunc) error {
+	for e, err := range v.Entries() {
+		if err := fn(e, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *Volume) entries(node *avsproperty.Node, current string, yield func(Entry, error) bool) bool {
+	for _, child := range node.Children() {
+		filename := child.AttributeValueNodeName(nameNodeName)
+		if len(filename) == 0 {
+			if !yield(Entry{Path: current, vol: v}, &InvalidNodeError{current, "name attribute not found"}) {
+				return false
+			}
+			continue
+		}
+		if !safeName(filename) {
+			if !yield(Entry{Path: current, vol: v}, &UnsafeNameError{current, filename}) {
+				return false
+			}
+			continue
+		}
+		realPath := path.Join(current, filename)
+
+		var ok bool
+		if entry := child.Name(); entry.Equals(dirNodeName) {
+			// recursively walk directory
+			ok = v.entries(child, realPath, yield)
+		} else if entry.Equals(fileNodeName) {
+			ok = yield(v.entry(child, realPath))
+		} else {
+			ok = yield(Entry{Path: realPath, vol: v}, &InvalidNodeError{realPath, "invalid file type: " + entry.String()})
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (v *Volume) entry(node *avsproperty.Node, realPath string) (Entry, error) {
+	e := Entry{
+		Path: realPath,
+		vol:  v,
+	}
+
+	// is the path obfuscated?
+	if child := node.SearchChildNodeName(pathNodeName); child != nil {
+		hashPath, err := formatHashPath(child.BinaryValue())
+		if err != nil {
+			return e, &InvalidNodeError{realPath, err.Error()}
+		}
+		e.HashPath = hashPath
+	}
+
+	// is the file encrypted under drmfs?
+	if child := node.SearchChildNodeName(keyNodeName); child != nil {
+		e.Key = uint32(child.UintValue())
+	}
+	return e, nil
+}
+
+// contentSize returns the size of the decrypted contents of a backing file of the specified size
+func (e Entry) contentSize(size int64) int64 {
+	if e.Key != 0 {
+		return keyring.ContentSize(size)
+	}
+	return size
+}
+
+// layer returns the volume that stores the backing file of e
+func (v *Volume) layer(e Entry) *Volume {
+	if e.vol != nil {
+		return e.vol
+	}
+	return v
+}
+
+// Stat returns a FileInfo describing the backing file of e
+func (v *Volume) Stat(e Entry) (fs.FileInfo, error) {
+	return fs.Stat(v.layer(e).src, e.BackingPath())
+}
+
+// open opens the backing file of e, and returns a reader that decrypts its contents
+func (v *Volume) open(e Entry) (io.Reader, fs.File, error) {
+	v = v.layer(e)
+	f, err := v.src.Open(e.BackingPath())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rd := io.Reader(f)
+	if e.Key != 0 {
+		if rd, err = v.keyring.MakeReader(f, e.Key); err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+	}
+	return rd, f, nil
+}
+
+// Lookup returns the entry of the file at the specified real path
+func (v *Volume) Lookup(name string) (Entry, bool) {
+	for e, err := range v.Entries() {
+		if err == nil && e.Path == name {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+// ReadFile reads and decrypts the file at the specified real path
+func (v *Volume) ReadFile(name string) ([]byte, error) {
+	e, ok := v.Lookup(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	rd, err := e.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+	return io.ReadAll(rd)
+}
@@ -0,0 +1,106 @@
+package drmfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/YoshihikoAbe/avsproperty"
+)
+
+var unsafeNames = []string{
+	"..",
+	".",
+	"../../escape",
+	"/etc/passwd",
+	`..\..\evil.dll`,
+	"C:evil",
+	`C:\evil`,
+}
+
+// hostileVolume returns a volume whose file list contains nodes with unsafe
+// names ahead of testFiles. Their dst_path refers to an existing backing file.
+func hostileVolume(t *testing.T) *Volume {
+	t.Helper()
+
+	fix := newTestFixture()
+	fileinfo, _ := avsproperty.NewNode("fileinfo")
+	for _, name := range unsafeNames {
+		node, _ := fileinfo.NewNode("file")
+		node.SetAttribute("name", name)
+		if _, err := node.NewNodeWithValue("dst_path", avsproperty.BinValue(fix.obfuscator.Sum("readme"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir, _ := fileinfo.NewNode("dir")
+	dir.SetAttribute("name", "..")
+	for _, f := range testFiles {
+		fix.addFileNode(t, dir, f)
+	}
+	for _, f := range testFiles {
+		fix.addFileNode(t, fileinfo, f)
+	}
+	fix.build(t, testFiles, fileinfo)
+
+	v, err := OpenFS(fix.mapFS(), fix.ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestUnsafeNames(t *testing.T) {
+	v := hostileVolume(t)
+
+	unsafe, valid := 0, 0
+	for e, err := range v.Entries() {
+		var nameErr *UnsafeNameError
+		if errors.As(err, &nameErr) {
+			unsafe++
+			continue
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if !fs.ValidPath(e.Path) {
+			t.Errorf("unsafe path: %s", e.Path)
+		}
+		valid++
+	}
+	// the files of the ".." directory are skipped along with it
+	if unsafe != len(unsafeNames)+1 || valid != len(testFiles) {
+		t.Fatalf("%d unsafe, %d valid entries", unsafe, valid)
+	}
+
+	for _, strict := range []bool{false, true} {
+		ch, report := v.Dump(&DumpOptions{Metadata: MetadataSkip, Strict: strict})
+		for f := range ch {
+			if !fs.ValidPath(f.Path) {
+				t.Errorf("unsafe path dumped: %s", f.Path)
+			}
+			io.Copy(io.Discard, f.Reader)
+			f.Close()
+		}
+
+		if strict {
+			if !report.Canceled || report.TotalOK != 0 || report.TotalInvalidNode != 1 {
+				t.Errorf("strict dump wasn't aborted: %+v", report)
+			}
+		} else if report.Canceled || report.TotalOK != len(testFiles) || report.TotalInvalidNode != len(unsafeNames)+1 {
+			t.Errorf("unexpected report: %+v", report)
+		}
+	}
+}
+
+func TestSafeName(t *testing.T) {
+	for _, name := range []string{"a.txt", "...", "..a", "a..", "C", "ab:c", ".hidden"} {
+		if !safeName(name) {
+			t.Errorf("%q is safe", name)
+		}
+	}
+	for _, name := range append(unsafeNames, "a/b", `a\b`, "a\x00b") {
+		if safeName(name) {
+			t.Errorf("%q is unsafe", name)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/YoshihikoAbe/eapki/dongle"
+	"github.com/YoshihikoAbe/eapki/drmfs"
+	"github.com/spf13/cobra"
+)
+
+// detectCmd represents the detect command
+var detectCmd = &cobra.Command{
+	Use:   "detect ROOT [CODES...]",
+	Short: "Detect the contents code of an encrypted filesystem",
+	Long: `Detect the contents code of an encrypted filesystem.
+Candidate codes are taken from the arguments, --codes, and the keyring dumps passed with --key.
+If no candidates are specified, the contents code of the connected license key is tried.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  runDetect,
+}
+
+func init() {
+	rootCmd.AddCommand(detectCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// detectCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// detectCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	detectCmd.Flags().StringArrayP("key", "k", nil, "Keyring dump file whose contents code is a candidate. May be repeated")
+	detectCmd.Flags().StringP("codes", "c", "", "Read candidate codes from this file, one per line")
+	detectCmd.Flags().BoolP("dongle", "d", false, "Also try the contents code of the connected license key")
+}
+
+func runDetect(cmd *cobra.Command, args []string) {
+	root := args[0]
+	keyFiles, _ := cmd.Flags().GetStringArray("key")
+	codesFile, _ := cmd.Flags().GetString("codes")
+	useDongle, _ := cmd.Flags().GetBool("dongle")
+
+	candidates := args[1:]
+	// maps candidates to the keyring dump they were taken from
+	sources := map[string]string{}
+	for _, name := range keyFiles {
+		ks, err := loadKeyFile(name)
+		if err != nil {
+			fatal(name+":", err)
+		}
+		candidates = append(candidates, ks.ContentsCode())
+		sources[ks.ContentsCode()] = name
+	}
+	if codesFile != "" {
+		f, err := os.Open(codesFile)
+		if err != nil {
+			fatal(err)
+		}
+		codes, err := drmfs.ReadPathList(f)
+		f.Close()
+		if err != nil {
+			fatal(err)
+		}
+		for code := range codes {
+			candidates = append(candidates, code)
+		}
+	}
+	if useDongle || len(candidates) == 0 {
+		license, err := dongle.Find(dongle.LicenseKey)
+		if err != nil {
+			log.Println("failed to find license key:", err)
+		} else {
+			candidates = append(candidates, license.ContentsCode())
+			sources[license.ContentsCode()] = "license key"
+			license.Close()
+		}
+	}
+	if len(candidates) == 0 {
+		fatal("no candidate codes")
+	}
+
+	info, err := drmfs.DetectContentsCode(root, candidates)
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Println(info.Code, info.Version)
+	if source, ok := sources[info.Code]; ok {
+		log.Println("matching key source:", source)
+	}
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/YoshihikoAbe/eapki/dedup"
+	"github.com/YoshihikoAbe/eapki/drmfs"
+	"github.com/YoshihikoAbe/fsdump"
+	"github.com/spf13/cobra"
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff OLDROOT NEWROOT",
+	Short: "Compare two encrypted filesystems",
+	Long: `Compare two encrypted filesystems, and report the files that were added, removed,
+modified or re-keyed. Files are compared by the MD5 recorded in prop/filepath.xml, and
+files that are not recorded in both lists are decrypted and hashed.`,
+	Args: cobra.MinimumNArgs(2),
+	Run:  runDiff,
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// diffCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// diffCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	diffCmd.Flags().String("old-key", "", "Keyring dump file of OLDROOT")
+	diffCmd.Flags().String("new-key", "", "Keyring dump file of NEWROOT")
+	diffCmd.Flags().StringP("format", "f", "text", "Output format (text, json)")
+	diffCmd.Flags().String("patch", "", "Dump the added, modified and re-keyed files of NEWROOT to this directory")
+	diffCmd.Flags().IntP("workers", "w", 0, "Number of workers. Specify a value less than one, and the number of logical CPUs available to the process will be used")
+}
+
+func runDiff(cmd *cobra.Command, args []string) {
+	oldKeyFile, _ := cmd.Flags().GetString("old-key")
+	newKeyFile, _ := cmd.Flags().GetString("new-key")
+	format, _ := cmd.Flags().GetString("format")
+	patch, _ := cmd.Flags().GetString("patch")
+	workers, _ := cmd.Flags().GetInt("workers")
+	if format != "text" && format != "json" {
+		fatal("invalid format:", format)
+	}
+
+	oldVol := openDiffVolume(args[0], oldKeyFile)
+	newVol := openDiffVolume(args[1], newKeyFile)
+
+	d, err := drmfs.DiffVolumes(oldVol, newVol, &drmfs.DiffOptions{
+		ListPath: fileListPath,
+		Workers:  workers,
+	})
+	if err != nil {
+		fatal(err)
+	}
+
+	if format == "json" {
+		b, err := json.MarshalIndent(d, "", " ")
+		if err != nil {
+			fatal(err)
+		}
+		os.Stdout.Write(b)
+	} else {
+		for _, name := range d.Added {
+			fmt.Println("A", name)
+		}
+		for _, name := range d.Removed {
+			fmt.Println("D", name)
+		}
+		for _, name := range d.Modified {
+			fmt.Println("M", name)
+		}
+		for _, f := range d.Rekeyed {
+			fmt.Printf("K %s (%d -> %d)\n", f.Path, f.OldKey, f.NewKey)
+		}
+		log.Printf("%d added, %d removed, %d modified, %d re-keyed",
+			len(d.Added), len(d.Removed), len(d.Modified), len(d.Rekeyed))
+	}
+
+	if patch == "" {
+		return
+	}
+	ch, report := newVol.Dump(&drmfs.DumpOptions{
+		Filter:   &drmfs.Filter{Paths: d.Changed()},
+		Metadata: drmfs.MetadataSkip,
+	})
+	dumper := fsdump.Dumper{
+		Src:        dedup.BreakLinks(&fsdump.ChannelFileSource{Chan: ch}, patch),
+		Dest:       patch,
+		NumWorkers: workers,
+	}
+	dumper.Run()
+	printDumpReport(report)
+	if report.Failed() {
+		os.Exit(1)
+	}
+}
+
+func openDiffVolume(root, keyFile string) *drmfs.Volume {
+	ks, err := getKeySource(keyFile)
+	if err != nil {
+		log.Fatalln("failed to initialize key source:", err)
+	}
+	vol, err := drmfs.Open(root, ks)
+	if err != nil {
+		log.Fatalln(root+":", err)
+	}
+	return vol
+}
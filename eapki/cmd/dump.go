@@ -1,11 +1,23 @@
 package cmd
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
 
+	"github.com/YoshihikoAbe/avsproperty"
+	"github.com/YoshihikoAbe/eapki/archive"
+	"github.com/YoshihikoAbe/eapki/dedup"
 	"github.com/YoshihikoAbe/eapki/dongle"
 	"github.com/YoshihikoAbe/eapki/drmfs"
 	"github.com/YoshihikoAbe/eapki/keyring"
@@ -13,6 +25,9 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// location of the file integrity list inside of a drmfs
+const fileListPath = "prop/filepath.xml"
+
 // dumpCmd represents the dump command
 var dumpCmd = &cobra.Command{
 	Use:   "dump SOURCE DESTINATION",
@@ -36,13 +51,68 @@ func init() {
 	// dumpCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	dumpCmd.Flags().StringP("key", "k", "", "Keyring dump file")
 	dumpCmd.Flags().IntP("workers", "w", 0, "Number of workers. Specify a value less than one, and the number of logical CPUs available to the process will be used")
+	dumpCmd.Flags().Bool("incremental", false, "Skip files whose copy in DESTINATION matches the size and MD5 recorded in the source's prop/filepath.xml. If the source has no file list, the manifest of the previous dump is used instead")
+	dumpCmd.Flags().String("journal", "", "Record completely dumped files in this file, and skip files that it already contains. Use it to resume an interrupted dump. The journal is removed once the dump completes")
+	dumpCmd.Flags().StringP("format", "f", "", "Write an archive instead of a directory tree (tar, tar.zst, zip). By default, the format is inferred from the extension of DESTINATION")
+	dumpCmd.Flags().StringP("manifest", "m", "", "Write a manifest recording the provenance of every file next to DESTINATION (jsonl, csv)")
+	dumpCmd.Flags().String("metadata", "raw", "How to dump keyring.dat and file.inf: raw, skip, or decode (write file.inf.xml and keyring.dat.json instead)")
+	dumpCmd.Flags().BoolP("progress", "p", false, "Draw a progress bar with an ETA to standard error")
+	dumpCmd.Flags().String("convert-props", "", "Write binary property files as XML: replace (the default if no value is given) replaces the original, and keep writes the XML next to it with .xml appended to its name")
+	dumpCmd.Flags().Lookup("convert-props").NoOptDefVal = "replace"
+	dumpCmd.Flags().String("dedup", "", "Link files with identical contents to each other once the dump completes: hardlink (the default if no value is given) or reflink")
+	dumpCmd.Flags().Lookup("dedup").NoOptDefVal = "hardlink"
+	dumpCmd.Flags().String("store", "", "Deduplicate files through a content-addressed store shared by several dumps, such as those of successive versions. Implies --dedup")
+	dumpCmd.Flags().Bool("verify", false, "Check the size and MD5 of each file against the source's prop/filepath.xml while it is being written")
+	dumpCmd.Flags().Bool("strict", false, "Abort the dump if file.inf contains a name that could escape DESTINATION. By default, such files are skipped and reported as invalid nodes")
+	dumpCmd.Flags().String("report", "", "Write a JSON report of the outcome of the dump to this file")
+	addFilterFlags(dumpCmd)
+	addLayerFlags(dumpCmd)
 }
 
 func runDump(cmd *cobra.Command, args []string) {
-	src := args[0]
+	root := args[0]
 	dest := args[1]
 	keyFile, _ := cmd.Flags().GetString("key")
 	workers, _ := cmd.Flags().GetInt("workers")
+	incremental, _ := cmd.Flags().GetBool("incremental")
+	journalFile, _ := cmd.Flags().GetString("journal")
+	formatName, _ := cmd.Flags().GetString("format")
+	manifestFormat, _ := cmd.Flags().GetString("manifest")
+	reportFile, _ := cmd.Flags().GetString("report")
+	metadata, _ := cmd.Flags().GetString("metadata")
+	progress, _ := cmd.Flags().GetBool("progress")
+	strict, _ := cmd.Flags().GetBool("strict")
+	verify, _ := cmd.Flags().GetBool("verify")
+	convertProps, _ := cmd.Flags().GetString("convert-props")
+	dedupMode, _ := cmd.Flags().GetString("dedup")
+	store, _ := cmd.Flags().GetString("store")
+	filter, err := getFilter(cmd)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	format, isArchive := archive.DetectFormat(dest)
+	if formatName != "" {
+		if format, err = archive.ParseFormat(formatName); err != nil {
+			log.Fatalln(err)
+		}
+		isArchive = true
+	}
+	if isArchive && (incremental || journalFile != "") {
+		log.Fatalln("--incremental and --journal cannot be used when dumping to an archive")
+	}
+	var dedupOpts *dedup.Options
+	if dedupMode != "" || store != "" {
+		if isArchive {
+			log.Fatalln("--dedup and --store cannot be used when dumping to an archive")
+		}
+		dedupOpts = &dedup.Options{Store: store}
+		if dedupMode != "" {
+			if dedupOpts.Mode, err = dedup.ParseMode(dedupMode); err != nil {
+				log.Fatalln(err)
+			}
+		}
+	}
 
 	ks, err := getKeySource(keyFile)
 	if err != nil {
@@ -51,19 +121,337 @@ func runDump(cmd *cobra.Command, args []string) {
 
 	start := time.Now()
 
-	ch, err := drmfs.Dump(src, ks)
+	vol, err := openVolume(cmd, root, ks)
 	if err != nil {
 		log.Fatalln(err)
 	}
+	opts := &drmfs.DumpOptions{
+		Filter: filter,
+		// archives of identical contents should be identical
+		Sort:   isArchive,
+		Strict: strict,
+	}
+	switch metadata {
+	case "raw":
+		opts.Metadata = drmfs.MetadataRaw
+	case "skip":
+		opts.Metadata = drmfs.MetadataSkip
+	case "decode":
+		opts.Metadata = drmfs.MetadataDecode
+	default:
+		log.Fatalln("invalid metadata mode:", metadata)
+	}
+	switch convertProps {
+	case "":
+		opts.Props = drmfs.PropsRaw
+	case "replace":
+		opts.Props = drmfs.PropsConvert
+	case "keep":
+		opts.Props = drmfs.PropsConvertKeep
+	default:
+		log.Fatalln("invalid property conversion mode:", convertProps)
+	}
+	if incremental {
+		if opts.Incremental, err = openIncremental(vol, dest); err != nil {
+			log.Fatalln("failed to initialize incremental dump:", err)
+		}
+	}
+	if verify {
+		list, err := readFileList(vol)
+		if err == nil {
+			opts.Verify, err = drmfs.NewVerifier(list)
+		}
+		if err != nil {
+			log.Fatalln("failed to initialize verification:", err)
+		}
+	}
+	if journalFile != "" {
+		if opts.Journal, err = drmfs.OpenJournal(journalFile); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	var (
+		manifest        *os.File
+		manifestType    = drmfs.ManifestJSONL
+		manifestWriters []io.Writer
+		records         *bytes.Buffer
+	)
+	if manifestFormat != "" {
+		switch manifestFormat {
+		case "jsonl":
+			manifestType = drmfs.ManifestJSONL
+		case "csv":
+			manifestType = drmfs.ManifestCSV
+		default:
+			log.Fatalln("invalid manifest format:", manifestFormat)
+		}
+		if manifest, err = os.Create(manifestPath(dest, manifestFormat)); err != nil {
+			log.Fatalln(err)
+		}
+		manifestWriters = append(manifestWriters, manifest)
+	}
+	if dedupOpts != nil {
+		// deduplication uses the hashes recorded in the manifest
+		records = &bytes.Buffer{}
+		manifestWriters = append(manifestWriters, records)
+	}
+	if len(manifestWriters) > 0 {
+		opts.Manifest = drmfs.NewManifest(io.MultiWriter(manifestWriters...), manifestType)
+	}
+	if progress {
+		opts.Progress = newDumpProgress()
+	}
+
+	// stop cleanly on interrupt, so the journal, manifest and report are kept
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ch, report := vol.DumpContext(ctx, opts)
+	src := &fsdump.ChannelFileSource{Chan: ch}
 
-	dumper := fsdump.Dumper{
-		Src:        &fsdump.ChannelFileSource{Chan: ch},
-		Dest:       dest,
-		NumWorkers: workers,
+	archiveFailed := false
+	if isArchive {
+		if err := dumpArchive(dest, format, src); err != nil {
+			log.Println("failed to write archive:", err)
+			archiveFailed = true
+			// stop the dump, and wait for it to finish so the report is complete
+			stop()
+			for f := range ch {
+				f.Close()
+			}
+		}
+	} else {
+		dumper := fsdump.Dumper{
+			// the destination may have been deduplicated
+			Src:        dedup.BreakLinks(src, dest),
+			Dest:       dest,
+			NumWorkers: workers,
+		}
+		dumper.Run()
+	}
+
+	if opts.Journal != nil {
+		opts.Journal.Close()
+		if !report.Failed() {
+			os.Remove(journalFile)
+		}
+	}
+	if opts.Manifest != nil {
+		if err := opts.Manifest.Flush(); err != nil {
+			log.Println(err)
+		}
+	}
+	if manifest != nil {
+		manifest.Close()
+	}
+	if dedupOpts != nil {
+		dedupeDump(dest, records, dedupOpts)
 	}
-	dumper.Run()
 
 	log.Println("time elapsed:", time.Since(start))
+
+	printDumpReport(report)
+	if reportFile != "" {
+		b, err := json.MarshalIndent(report, "", " ")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if err := os.WriteFile(reportFile, b, 0666); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if report.Failed() || archiveFailed {
+		os.Exit(1)
+	}
+}
+
+func printDumpReport(report *drmfs.DumpReport) {
+	for _, failed := range []struct {
+		status string
+		files  []string
+	}{
+		{"missing", report.Missing},
+		{"decrypt failed", report.DecryptFailed},
+		{"invalid node", report.InvalidNode},
+		{"write failed", report.WriteFailed},
+		{"broken", report.Broken},
+	} {
+		for _, name := range failed.files {
+			log.Println(failed.status+":", name)
+		}
+	}
+	if report.Canceled {
+		log.Println("dump canceled")
+	}
+	log.Printf("%d files: %d ok, %d unchanged, %d missing, %d decrypt failed, %d invalid node, %d write failed, %d broken",
+		report.TotalFiles, report.TotalOK, report.TotalUnchanged, report.TotalMissing,
+		report.TotalDecryptFailed, report.TotalInvalidNode, report.TotalWriteFailed, report.TotalBroken)
+	if report.TotalConverted > 0 {
+		log.Printf("%d property files converted", report.TotalConverted)
+	}
+	if report.TotalVerified > 0 {
+		log.Printf("%d verified", report.TotalVerified)
+	}
+}
+
+// dedupeDump links the files of the dump at dest with identical contents,
+// using the hashes recorded in its manifest
+func dedupeDump(dest string, records io.Reader, opts *dedup.Options) {
+	recs, err := drmfs.ReadManifest(records)
+	if err != nil {
+		log.Println("failed to deduplicate dump:", err)
+		return
+	}
+	files := []dedup.File{}
+	for _, rec := range recs {
+		if (rec.Status == drmfs.StatusOK || rec.Status == drmfs.StatusUnchanged) && rec.SHA256 != "" {
+			files = append(files, dedup.File{Path: rec.Path, SHA256: rec.SHA256})
+		}
+	}
+	result := dedup.Dedupe(dest, files, opts)
+	log.Printf("%d duplicate files linked, %s saved", result.Linked, formatBytes(result.Saved))
+}
+
+func dumpArchive(name string, format archive.Format, src fsdump.FileSource) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	if err := archive.Write(bw, format, src); err != nil {
+		f.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// manifestPath returns the location of the manifest of the dump at dest
+func manifestPath(dest, format string) string {
+	return filepath.Clean(dest) + ".manifest." + format
+}
+
+func openIncremental(vol *drmfs.Volume, dest string) (*drmfs.Incremental, error) {
+	list, err := readFileList(vol)
+	if err != nil {
+		// fall back to the manifest of the previous dump
+		for _, format := range []string{"jsonl", "csv"} {
+			if f, err := os.Open(manifestPath(dest, format)); err == nil {
+				defer f.Close()
+				records, err := drmfs.ReadManifest(f)
+				if err != nil {
+					return nil, err
+				}
+				return drmfs.NewManifestIncremental(dest, records), nil
+			}
+		}
+		return nil, err
+	}
+	return drmfs.NewIncremental(dest, list)
+}
+
+// readFileList reads the file integrity list stored inside of a drmfs
+func readFileList(vol *drmfs.Volume) (*avsproperty.Node, error) {
+	b, err := vol.ReadFile(fileListPath)
+	if err != nil {
+		return nil, err
+	}
+	prop := &avsproperty.Property{}
+	if err := prop.Read(bytes.NewReader(b)); err != nil {
+		return nil, err
+	}
+	return prop.Root, nil
+}
+
+func addFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArray("include", nil, "Only include files whose real path matches this glob pattern (e.g. data/graphic/**). May be repeated")
+	cmd.Flags().StringArray("exclude", nil, "Exclude files whose real path matches this glob pattern. May be repeated")
+	cmd.Flags().StringArray("include-regex", nil, "Only include files whose real path matches this regular expression. May be repeated")
+	cmd.Flags().StringArray("exclude-regex", nil, "Exclude files whose real path matches this regular expression. May be repeated")
+	cmd.Flags().String("paths-from", "", "Only include the real paths listed in this file, one per line")
+}
+
+// getFilter builds a filter from the flags added by addFilterFlags. If no flags are set, nil is returned
+func getFilter(cmd *cobra.Command) (*drmfs.Filter, error) {
+	filter := &drmfs.Filter{}
+	filter.Include, _ = cmd.Flags().GetStringArray("include")
+	filter.Exclude, _ = cmd.Flags().GetStringArray("exclude")
+	includeRegexp, _ := cmd.Flags().GetStringArray("include-regex")
+	excludeRegexp, _ := cmd.Flags().GetStringArray("exclude-regex")
+	pathsFrom, _ := cmd.Flags().GetString("paths-from")
+
+	if len(filter.Include) == 0 && len(filter.Exclude) == 0 && len(includeRegexp) == 0 && len(excludeRegexp) == 0 && pathsFrom == "" {
+		return nil, nil
+	}
+
+	for _, expr := range includeRegexp {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		filter.IncludeRegexp = append(filter.IncludeRegexp, re)
+	}
+	for _, expr := range excludeRegexp {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		filter.ExcludeRegexp = append(filter.ExcludeRegexp, re)
+	}
+	if pathsFrom != "" {
+		f, err := os.Open(pathsFrom)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if filter.Paths, err = drmfs.ReadPathList(f); err != nil {
+			return nil, err
+		}
+	}
+
+	return filter, filter.Validate()
+}
+
+func addLayerFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArray("layer", nil, "Overlay the encrypted filesystem at PATH over SOURCE, such as an update directory. Its keyring dump file may be given with PATH=KEYFILE, if KEYFILE exists. Later layers override earlier ones. May be repeated")
+}
+
+// openVolume opens the encrypted filesystem at root, overlaid with the layers passed with --layer.
+// Layers without a keyring dump file use ks.
+func openVolume(cmd *cobra.Command, root string, ks keyring.KeySource) (*drmfs.Volume, error) {
+	vol, err := drmfs.Open(root, ks)
+	if err != nil {
+		return nil, err
+	}
+	layers, _ := cmd.Flags().GetStringArray("layer")
+	if len(layers) == 0 {
+		return vol, nil
+	}
+
+	vols := []*drmfs.Volume{vol}
+	for _, layer := range layers {
+		layerRoot, layerKs := layer, ks
+		// directories may contain "=", so only split off a key file that exists
+		if i := strings.LastIndex(layer, "="); i >= 0 && isRegularFile(layer[i+1:]) {
+			layerRoot = layer[:i]
+			if layerKs, err = loadKeyFile(layer[i+1:]); err != nil {
+				return nil, err
+			}
+		}
+		v, err := drmfs.Open(layerRoot, layerKs)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", layerRoot, err)
+		}
+		vols = append(vols, v)
+	}
+	return drmfs.Overlay(vols...), nil
+}
+
+func isRegularFile(name string) bool {
+	info, err := os.Stat(name)
+	return err == nil && info.Mode().IsRegular()
 }
 
 func getKeySource(keyFile string) (keyring.KeySource, error) {
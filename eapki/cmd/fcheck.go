@@ -2,7 +2,10 @@ package cmd
 
 import (
 	"encoding/json"
+	"fmt"
+	"log"
 	"os"
+	"path/filepath"
 
 	"github.com/YoshihikoAbe/avsproperty"
 	"github.com/YoshihikoAbe/eapki/drmfs"
@@ -11,10 +14,20 @@ import (
 
 // fcheckCmd represents the fcheck command
 var fcheckCmd = &cobra.Command{
-	Use:   "fcheck ROOT ALLFILES/FILEPATH",
+	Use:   "fcheck ROOT ALLFILES/FILEPATH | fcheck --drmfs SOURCE [FILEPATH]",
 	Short: "Perform file integrity check",
-	Args:  cobra.MinimumNArgs(2),
-	Run:   runCheck,
+	Long: `Perform file integrity check.
+Files are hashed in parallel and classified as missing, size mismatched, hash mismatched,
+or unreadable. Files under ROOT that are not in the list are reported as extra.
+The command exits with a non-zero status if any file is not intact.
+
+Both the filepath.xml and allfiles.xml list schemas are supported.
+
+With --drmfs, the files of an encrypted filesystem are verified directly, without
+dumping them first, against the file list stored inside of it (prop/filepath.xml
+by default).`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  runCheck,
 }
 
 func init() {
@@ -29,24 +42,84 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// fcheckCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	fcheckCmd.Flags().Bool("drmfs", false, "Verify an encrypted filesystem without dumping it")
+	fcheckCmd.Flags().StringP("key", "k", "", "Keyring dump file. Only used with --drmfs")
+	fcheckCmd.Flags().IntP("workers", "w", 0, "Number of workers. Specify a value less than one, and the number of logical CPUs available to the process will be used")
+	fcheckCmd.Flags().StringArrayP("ignore", "i", nil, "Neither check nor report as extra the files whose path matches this glob pattern (e.g. dev/nvram/**). May be repeated")
+	fcheckCmd.Flags().BoolP("progress", "p", false, "Print progress to standard error")
 }
 
 func runCheck(cmd *cobra.Command, args []string) {
 	root := args[0]
-	fileList := args[1]
+	isDrmfs, _ := cmd.Flags().GetBool("drmfs")
+	keyFile, _ := cmd.Flags().GetString("key")
+	workers, _ := cmd.Flags().GetInt("workers")
+	progress, _ := cmd.Flags().GetBool("progress")
+	ignore, _ := cmd.Flags().GetStringArray("ignore")
 
-	prop := avsproperty.Property{}
-	if err := prop.ReadFile(fileList); err != nil {
-		fatal(err)
+	opts := &drmfs.CheckOptions{
+		Workers: workers,
+		Ignore:  ignore,
+	}
+	if progress {
+		opts.Progress = func(done, total int) {
+			fmt.Fprintf(os.Stderr, "\r%d/%d files checked", done, total)
+			if done == total {
+				fmt.Fprintln(os.Stderr)
+			}
+		}
 	}
 
-	result, err := drmfs.CheckContents(prop.Root, root)
-	if err != nil {
-		fatal(err)
+	var result *drmfs.CheckResult
+	if isDrmfs {
+		result = checkDrmfs(root, keyFile, args[1:], opts)
+	} else {
+		if len(args) < 2 {
+			fatal("no file list specified")
+		}
+		prop := avsproperty.Property{}
+		if err := prop.ReadFile(args[1]); err != nil {
+			fatal(err)
+		}
+		// don't report the list itself, nor the metadata files written by dump as extra
+		opts.Ignore = append(opts.Ignore, "keyring.dat", "file.inf", "keyring.dat.json", "file.inf.xml")
+		if rel, err := filepath.Rel(root, args[1]); err == nil && filepath.IsLocal(rel) {
+			opts.Ignore = append(opts.Ignore, filepath.ToSlash(rel))
+		}
+
+		var err error
+		if result, err = drmfs.CheckContents(prop.Root, root, opts); err != nil {
+			fatal(err)
+		}
 	}
 	b, err := json.MarshalIndent(result, "", " ")
 	if err != nil {
 		fatal(err)
 	}
 	os.Stdout.Write(b)
+
+	if !result.OK() {
+		os.Exit(1)
+	}
+}
+
+func checkDrmfs(root, keyFile string, args []string, opts *drmfs.CheckOptions) *drmfs.CheckResult {
+	listPath := fileListPath
+	if len(args) > 0 {
+		listPath = args[0]
+	}
+
+	ks, err := getKeySource(keyFile)
+	if err != nil {
+		log.Fatalln("failed to initialize key source:", err)
+	}
+	vol, err := drmfs.Open(root, ks)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	result, err := vol.CheckContents(listPath, opts)
+	if err != nil {
+		fatal(err)
+	}
+	return result
 }
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/YoshihikoAbe/avsproperty"
+	"github.com/YoshihikoAbe/eapki/drmfs"
+	"github.com/spf13/cobra"
+)
+
+// fcheckGenerateCmd represents the fcheck generate command
+var fcheckGenerateCmd = &cobra.Command{
+	Use:   "generate ROOT OUT",
+	Short: "Generate a file integrity list from a directory",
+	Long: `Generate a file integrity list from a directory.
+The list is written in the schema of prop/filepath.xml, and can be checked with fcheck.`,
+	Args: cobra.MinimumNArgs(2),
+	Run:  runCheckGenerate,
+}
+
+func init() {
+	fcheckCmd.AddCommand(fcheckGenerateCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// fcheckGenerateCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// fcheckGenerateCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	fcheckGenerateCmd.Flags().Bool("xml", false, "Write the list as XML instead of a binary property")
+	fcheckGenerateCmd.Flags().StringArrayP("ignore", "i", nil, "Exclude files whose path matches this glob pattern (e.g. dev/nvram/**). May be repeated")
+	fcheckGenerateCmd.Flags().IntP("workers", "w", 0, "Number of workers. Specify a value less than one, and the number of logical CPUs available to the process will be used")
+	fcheckGenerateCmd.Flags().BoolP("progress", "p", false, "Print progress to standard error")
+}
+
+func runCheckGenerate(cmd *cobra.Command, args []string) {
+	root := args[0]
+	out := args[1]
+	asXML, _ := cmd.Flags().GetBool("xml")
+	ignore, _ := cmd.Flags().GetStringArray("ignore")
+	workers, _ := cmd.Flags().GetInt("workers")
+	progress, _ := cmd.Flags().GetBool("progress")
+
+	opts := &drmfs.CheckOptions{
+		Workers: workers,
+		Ignore:  ignore,
+	}
+	if progress {
+		opts.Progress = func(done, total int) {
+			fmt.Fprintf(os.Stderr, "\r%d/%d files hashed", done, total)
+			if done == total {
+				fmt.Fprintln(os.Stderr)
+			}
+		}
+	}
+
+	list, err := drmfs.GenerateList(root, opts)
+	if err != nil {
+		fatal(err)
+	}
+
+	prop := &avsproperty.Property{Root: list}
+	if asXML {
+		prop.Settings.Format = avsproperty.FormatPrettyXML
+	}
+	if err := prop.WriteFile(out); err != nil {
+		fatal(err)
+	}
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/YoshihikoAbe/avsproperty"
+	"github.com/YoshihikoAbe/eapki/drmfs"
+	"github.com/spf13/cobra"
+)
+
+// fileinfoCmd represents the fileinfo command
+var fileinfoCmd = &cobra.Command{
+	Use:   "fileinfo ROOT",
+	Short: "Export the file list (file.inf) of an encrypted filesystem",
+	Long: `Export the file list (file.inf) of an encrypted filesystem as XML or JSON.
+dst_path values are rendered as hash paths, file nodes are annotated with their real
+path, and key_idx nodes with whether the file is encrypted.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  runFileinfo,
+}
+
+func init() {
+	rootCmd.AddCommand(fileinfoCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// fileinfoCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// fileinfoCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	fileinfoCmd.Flags().StringP("key", "k", "", "Keyring dump file")
+	fileinfoCmd.Flags().StringP("format", "f", "xml", "Output format (xml, json)")
+}
+
+// jsonNode is the JSON representation of a property node
+type jsonNode struct {
+	Name       string            `json:"name"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Value      any               `json:"value,omitempty"`
+	Children   []*jsonNode       `json:"children,omitempty"`
+}
+
+func newJSONNode(node *avsproperty.Node) *jsonNode {
+	n := &jsonNode{
+		Name:  node.Name().String(),
+		Value: node.Value(),
+	}
+	for _, attr := range node.Attributes() {
+		if n.Attributes == nil {
+			n.Attributes = map[string]string{}
+		}
+		n.Attributes[attr.Key().String()] = attr.Value
+	}
+	for _, child := range node.Children() {
+		n.Children = append(n.Children, newJSONNode(child))
+	}
+	return n
+}
+
+func runFileinfo(cmd *cobra.Command, args []string) {
+	root := args[0]
+	keyFile, _ := cmd.Flags().GetString("key")
+	format, _ := cmd.Flags().GetString("format")
+	if format != "xml" && format != "json" {
+		fatal("invalid format:", format)
+	}
+
+	ks, err := getKeySource(keyFile)
+	if err != nil {
+		log.Fatalln("failed to initialize key source:", err)
+	}
+	vol, err := drmfs.Open(root, ks)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fileList, err := vol.DecodeFileList()
+	if err != nil {
+		fatal(err)
+	}
+
+	if format == "json" {
+		b, err := json.MarshalIndent(newJSONNode(fileList), "", " ")
+		if err != nil {
+			fatal(err)
+		}
+		os.Stdout.Write(b)
+		return
+	}
+
+	prop := &avsproperty.Property{
+		Settings: avsproperty.PropertySettings{Format: avsproperty.FormatPrettyXML},
+		Root:     fileList,
+	}
+	if err := prop.Write(os.Stdout); err != nil {
+		fatal(err)
+	}
+}
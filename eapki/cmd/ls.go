@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/YoshihikoAbe/eapki/drmfs"
+	"github.com/spf13/cobra"
+)
+
+// lsCmd represents the ls command
+var lsCmd = &cobra.Command{
+	Use:   "ls ROOT",
+	Short: "List the contents of an encrypted filesystem without decrypting them",
+	Args:  cobra.MinimumNArgs(1),
+	Run:   runLs,
+}
+
+func init() {
+	rootCmd.AddCommand(lsCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// lsCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// lsCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	lsCmd.Flags().StringP("key", "k", "", "Keyring dump file")
+	lsCmd.Flags().StringP("format", "f", "text", "Output format (text, tree, json)")
+	addLayerFlags(lsCmd)
+}
+
+type lsEntry struct {
+	drmfs.Entry
+	Exists bool  `json:"exists"`
+	Size   int64 `json:"size"`
+}
+
+func runLs(cmd *cobra.Command, args []string) {
+	root := args[0]
+	keyFile, _ := cmd.Flags().GetString("key")
+	format, _ := cmd.Flags().GetString("format")
+
+	printEntries, ok := lsFormats[format]
+	if !ok {
+		fatal("invalid format:", format)
+	}
+
+	ks, err := getKeySource(keyFile)
+	if err != nil {
+		log.Fatalln("failed to initialize key source:", err)
+	}
+	vol, err := openVolume(cmd, root, ks)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	entries := []lsEntry{}
+	vol.Walk(func(e drmfs.Entry, err error) error {
+		if err != nil {
+			log.Println(err)
+			return nil
+		}
+
+		le := lsEntry{Entry: e}
+		if info, err := vol.Stat(e); err == nil {
+			le.Exists = true
+			le.Size = info.Size()
+		}
+		entries = append(entries, le)
+		return nil
+	})
+
+	if err := printEntries(os.Stdout, entries); err != nil {
+		fatal(err)
+	}
+}
+
+// lsFormats contains the functions that print entries in each output format
+var lsFormats = map[string]func(io.Writer, []lsEntry) error{
+	"text": printLsText,
+	"tree": printLsTree,
+	"json": printLsJSON,
+}
+
+func printLsText(wr io.Writer, entries []lsEntry) error {
+	w := tabwriter.NewWriter(wr, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tDST_PATH\tKEY_IDX\tEXISTS\tSIZE\tLAYER")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%t\t%d\t%d\n", e.Path, e.HashPath, e.Key, e.Exists, e.Size, e.Layer)
+	}
+	return w.Flush()
+}
+
+func printLsTree(w io.Writer, entries []lsEntry) error {
+	var current []string
+	for _, e := range entries {
+		elems := strings.Split(e.Path, "/")
+		dirs, name := elems[:len(elems)-1], elems[len(elems)-1]
+
+		// find where this entry's directory diverges from the previous one
+		i := 0
+		for i < len(current) && i < len(dirs) && current[i] == dirs[i] {
+			i++
+		}
+		for ; i < len(dirs); i++ {
+			if _, err := fmt.Fprintf(w, "%s%s/\n", strings.Repeat("  ", i), dirs[i]); err != nil {
+				return err
+			}
+		}
+		current = dirs
+
+		status := ""
+		if !e.Exists {
+			status = ", missing"
+		}
+		if e.Layer > 0 {
+			status += fmt.Sprintf(", layer %d", e.Layer)
+		}
+		if _, err := fmt.Fprintf(w, "%s%s (key %d, %d bytes%s) %s\n", strings.Repeat("  ", len(dirs)), name, e.Key, e.Size, status, e.HashPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printLsJSON(w io.Writer, entries []lsEntry) error {
+	b, err := json.MarshalIndent(entries, "", " ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
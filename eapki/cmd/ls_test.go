@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/YoshihikoAbe/eapki/drmfs"
+)
+
+var testLsEntries = []lsEntry{
+	{drmfs.Entry{Path: "data/a.txt", HashPath: "c/b/4/e0907fc12bc293c3ccc29f70bb8296f254d4d", Key: 1}, true, 11},
+	{drmfs.Entry{Path: "data/sub/b.bin", HashPath: "9/3/c/671e1bb8bfe0613188ff228f60520a8f9426c", Key: 2, Layer: 1}, false, 0},
+	{drmfs.Entry{Path: "readme", HashPath: "a/2/d/a4f84da1bac86a88f089e42039950868ed43a"}, true, 9},
+}
+
+func TestPrintLs(t *testing.T) {
+	for format, expected := range map[string]string{
+		"text": `PATH            DST_PATH                                     KEY_IDX  EXISTS  SIZE  LAYER
+data/a.txt      c/b/4/e0907fc12bc293c3ccc29f70bb8296f254d4d  1        true    11    0
+data/sub/b.bin  9/3/c/671e1bb8bfe0613188ff228f60520a8f9426c  2        false   0     1
+readme          a/2/d/a4f84da1bac86a88f089e42039950868ed43a  0        true    9     0
+`,
+		"tree": `data/
+  a.txt (key 1, 11 bytes) c/b/4/e0907fc12bc293c3ccc29f70bb8296f254d4d
+  sub/
+    b.bin (key 2, 0 bytes, missing, layer 1) 9/3/c/671e1bb8bfe0613188ff228f60520a8f9426c
+readme (key 0, 9 bytes) a/2/d/a4f84da1bac86a88f089e42039950868ed43a
+`,
+		"json": `[
+ {
+  "path": "data/a.txt",
+  "dst_path": "c/b/4/e0907fc12bc293c3ccc29f70bb8296f254d4d",
+  "key_idx": 1,
+  "exists": true,
+  "size": 11
+ },
+ {
+  "path": "data/sub/b.bin",
+  "dst_path": "9/3/c/671e1bb8bfe0613188ff228f60520a8f9426c",
+  "key_idx": 2,
+  "layer": 1,
+  "exists": false,
+  "size": 0
+ },
+ {
+  "path": "readme",
+  "dst_path": "a/2/d/a4f84da1bac86a88f089e42039950868ed43a",
+  "key_idx": 0,
+  "exists": true,
+  "size": 9
+ }
+]`,
+	} {
+		buf := &bytes.Buffer{}
+		if err := lsFormats[format](buf, testLsEntries); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != expected {
+			t.Errorf("%s: unexpected output:\n%s", format, buf)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/YoshihikoAbe/eapki/drmfs"
+	"github.com/spf13/cobra"
+)
+
+// mapCmd represents the map command
+var mapCmd = &cobra.Command{
+	Use:   "map ROOT",
+	Short: "Map the obfuscated files of an encrypted filesystem to their real paths",
+	Long: `Map the obfuscated files of an encrypted filesystem to their real paths.
+Files that are not referenced by the file list (orphaned), and referenced files that
+don't exist (missing) are reported as well.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  runMap,
+}
+
+func init() {
+	rootCmd.AddCommand(mapCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// mapCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// mapCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	mapCmd.Flags().StringP("key", "k", "", "Keyring dump file")
+	mapCmd.Flags().Bool("json", false, "Output the map, orphaned and missing files as JSON")
+	mapCmd.Flags().Bool("orphaned", false, "Only list orphaned files")
+	mapCmd.Flags().Bool("missing", false, "Only list missing files")
+}
+
+func runMap(cmd *cobra.Command, args []string) {
+	root := args[0]
+	keyFile, _ := cmd.Flags().GetString("key")
+	asJSON, _ := cmd.Flags().GetBool("json")
+	orphaned, _ := cmd.Flags().GetBool("orphaned")
+	missing, _ := cmd.Flags().GetBool("missing")
+
+	ks, err := getKeySource(keyFile)
+	if err != nil {
+		log.Fatalln("failed to initialize key source:", err)
+	}
+	vol, err := drmfs.Open(root, ks)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	pm, err := vol.Map()
+	if err != nil {
+		fatal(err)
+	}
+
+	switch {
+	case asJSON:
+		b, err := json.MarshalIndent(pm, "", " ")
+		if err != nil {
+			fatal(err)
+		}
+		os.Stdout.Write(b)
+	case orphaned || missing:
+		if orphaned {
+			for _, name := range pm.Orphaned {
+				fmt.Println(name)
+			}
+		}
+		if missing {
+			for _, name := range pm.Missing {
+				fmt.Println(name)
+			}
+		}
+	default:
+		for _, f := range pm.Mapped {
+			fmt.Println(f.BackingPath, f.Path)
+		}
+		log.Printf("%d mapped, %d orphaned, %d missing", len(pm.Mapped), len(pm.Orphaned), len(pm.Missing))
+	}
+}
@@ -1,7 +1,13 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
 
 	"github.com/YoshihikoAbe/eapki/drmfs"
 	"github.com/spf13/cobra"
@@ -9,15 +15,21 @@ import (
 
 // pathCmd represents the path command
 var pathCmd = &cobra.Command{
-	Use:   "path CODE PATH",
+	Use:   "path CODE [PATH...]",
 	Short: "Convert a path/filename to an obfuscated drmfs path",
-	Args:  cobra.MinimumNArgs(2),
-
-	Run: func(cmd *cobra.Command, args []string) {
-		p := drmfs.PathObfuscator{}
-		p.Init(args[0])
-		fmt.Println(p.Obfuscate(args[1]))
+	Long: `Convert a path/filename to an obfuscated drmfs path.
+With --input, paths are read from a file (or standard input) one per line, and
+hashed in parallel. With --root, only the paths whose hashed file exists under
+ROOT, but which are not recorded in its file.inf, are printed. This identifies
+files stored outside of file.inf.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		input, _ := cmd.Flags().GetString("input")
+		if len(args) < 1 || len(args) < 2 && input == "" {
+			return fmt.Errorf("requires CODE and at least one PATH, or --input")
+		}
+		return nil
 	},
+	Run: runPath,
 }
 
 func init() {
@@ -32,4 +44,115 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// pathCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	pathCmd.Flags().StringP("input", "i", "", "Read paths from this file, one per line. Specify - to read from standard input")
+	pathCmd.Flags().StringP("root", "r", "", "Only print paths whose hashed file exists under this drmfs root, and that its file.inf doesn't record")
+	pathCmd.Flags().StringP("key", "k", "", "Keyring dump file of the drmfs passed with --root")
+	pathCmd.Flags().IntP("workers", "w", 0, "Number of workers. Specify a value less than one, and the number of logical CPUs available to the process will be used")
+}
+
+// number of paths that are hashed at once in batch mode
+const pathBatchSize = 1 << 16
+
+func runPath(cmd *cobra.Command, args []string) {
+	input, _ := cmd.Flags().GetString("input")
+	root, _ := cmd.Flags().GetString("root")
+	workers, _ := cmd.Flags().GetInt("workers")
+	keyFile, _ := cmd.Flags().GetString("key")
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	// files recorded in file.inf are already known
+	var known map[string]bool
+	if root != "" {
+		ks, err := getKeySource(keyFile)
+		if err != nil {
+			fatal("failed to initialize key source:", err)
+		}
+		vol, err := drmfs.Open(root, ks)
+		if err != nil {
+			fatal(err)
+		}
+		known = map[string]bool{}
+		for e, err := range vol.Entries() {
+			if err == nil {
+				known[e.BackingPath()] = true
+			}
+		}
+	}
+
+	p := drmfs.PathObfuscator{}
+	p.Init(args[0])
+
+	// preserve the original output format when converting a single path
+	if input == "" && root == "" && len(args) == 2 {
+		fmt.Println(p.Obfuscate(args[1]))
+		return
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	hashPaths := func(paths []string) {
+		hashes := make([]string, len(paths))
+		found := make([]bool, len(paths))
+
+		wg := sync.WaitGroup{}
+		for w := range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := w; i < len(paths); i += workers {
+					hashes[i] = p.Obfuscate(paths[i])
+					if root != "" {
+						_, err := os.Stat(filepath.Join(root, hashes[i]))
+						found[i] = err == nil && !known[hashes[i]]
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		for i, hash := range hashes {
+			if root == "" || found[i] {
+				fmt.Fprintln(out, hash, paths[i])
+			}
+		}
+	}
+
+	if len(args) > 1 {
+		hashPaths(args[1:])
+	}
+	if input == "" {
+		return
+	}
+
+	var rd io.Reader = os.Stdin
+	if input != "-" {
+		f, err := os.Open(input)
+		if err != nil {
+			// fatal exits without running deferred calls
+			out.Flush()
+			fatal(err)
+		}
+		defer f.Close()
+		rd = f
+	}
+
+	scanner := bufio.NewScanner(rd)
+	batch := make([]string, 0, pathBatchSize)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			batch = append(batch, line)
+		}
+		if len(batch) == pathBatchSize {
+			hashPaths(batch)
+			batch = batch[:0]
+		}
+	}
+	hashPaths(batch)
+	if err := scanner.Err(); err != nil {
+		out.Flush()
+		fatal(err)
+	}
 }
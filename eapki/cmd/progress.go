@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/YoshihikoAbe/eapki/drmfs"
+)
+
+const progressBarWidth = 30
+
+// newDumpProgress returns a progress callback that draws a progress bar with an ETA to standard error
+func newDumpProgress() func(drmfs.DumpProgress) {
+	start := time.Now()
+	last := time.Time{}
+
+	return func(p drmfs.DumpProgress) {
+		done := p.Files >= p.TotalFiles
+		if !done && time.Since(last) < 100*time.Millisecond {
+			return
+		}
+		last = time.Now()
+
+		ratio := 1.0
+		if p.TotalBytes > 0 {
+			ratio = min(float64(p.Bytes)/float64(p.TotalBytes), 1)
+		}
+		eta := "?"
+		if ratio > 0 {
+			elapsed := time.Since(start)
+			eta = (time.Duration(float64(elapsed)/ratio) - elapsed).Round(time.Second).String()
+		}
+
+		filled := int(ratio * progressBarWidth)
+		fmt.Fprintf(os.Stderr, "\r[%s%s] %3.0f%% %d/%d files %s/%s ETA %s ",
+			strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled), ratio*100,
+			p.Files, p.TotalFiles, formatBytes(p.Bytes), formatBytes(p.TotalBytes), eta)
+		if done {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
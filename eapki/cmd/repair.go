@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/YoshihikoAbe/eapki/drmfs"
+	"github.com/spf13/cobra"
+)
+
+// repairCmd represents the repair command
+var repairCmd = &cobra.Command{
+	Use:   "repair SOURCE DESTINATION",
+	Short: "Re-dump the broken and missing files of a dump",
+	Long: `Re-dump the broken and missing files of a dump.
+DESTINATION is checked against the file list stored inside of SOURCE, and only the files
+that are broken or missing are dumped again and verified. Files that are still broken
+afterwards are damaged at the source, and are printed to standard output.`,
+	Args: cobra.MinimumNArgs(2),
+	Run:  runRepair,
+}
+
+func init() {
+	rootCmd.AddCommand(repairCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// repairCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// repairCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	repairCmd.Flags().StringP("key", "k", "", "Keyring dump file")
+	repairCmd.Flags().IntP("workers", "w", 0, "Number of workers. Specify a value less than one, and the number of logical CPUs available to the process will be used")
+	repairCmd.Flags().StringArrayP("ignore", "i", nil, "Neither check nor repair the files whose path matches this glob pattern (e.g. dev/nvram/**). May be repeated")
+}
+
+func runRepair(cmd *cobra.Command, args []string) {
+	root := args[0]
+	dest := args[1]
+	keyFile, _ := cmd.Flags().GetString("key")
+	workers, _ := cmd.Flags().GetInt("workers")
+	ignore, _ := cmd.Flags().GetStringArray("ignore")
+
+	ks, err := getKeySource(keyFile)
+	if err != nil {
+		log.Fatalln("failed to initialize key source:", err)
+	}
+
+	start := time.Now()
+
+	vol, err := drmfs.Open(root, ks)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	list, err := readFileList(vol)
+	if err != nil {
+		log.Fatalln("failed to read file list:", err)
+	}
+
+	result, err := vol.Repair(list, dest, &drmfs.CheckOptions{
+		Workers: workers,
+		Ignore:  ignore,
+	})
+	if err != nil {
+		fatal(err)
+	}
+	before := result.Before
+	log.Printf("%d files checked: %d broken, %d missing", before.TotalFiles, before.TotalBroken, before.TotalMissing)
+	if result.After == nil {
+		return
+	}
+	printDumpReport(result.Dump)
+
+	after := result.After
+	for _, name := range append(after.Broken, after.Missing...) {
+		fmt.Println(name)
+	}
+
+	log.Println("time elapsed:", time.Since(start))
+	log.Printf("%d files repaired, %d still broken", after.TotalFiles-after.TotalBroken-after.TotalMissing,
+		after.TotalBroken+after.TotalMissing)
+	if !result.OK() {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/YoshihikoAbe/eapki/drmfs"
+	"github.com/spf13/cobra"
+	"golang.org/x/net/webdav"
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve ROOT",
+	Short: "Serve the contents of an encrypted filesystem over HTTP",
+	Long: `Serve the contents of an encrypted filesystem over HTTP, decrypting files on the fly.
+Directory listings and range requests are supported. With --webdav, the tree is
+also exposed as a read-only WebDAV share under /dav/.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  runServe,
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// serveCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// serveCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	serveCmd.Flags().StringP("key", "k", "", "Keyring dump file")
+	serveCmd.Flags().StringP("address", "a", "127.0.0.1:8080", "Address to listen on")
+	serveCmd.Flags().StringP("token", "t", "", "Require clients to authenticate with this token, either as a bearer token, a basic auth password, or a token query parameter. The query parameter sets a cookie, so links followed from a directory listing stay authenticated")
+	serveCmd.Flags().Bool("webdav", false, "Expose a read-only WebDAV share under /dav/")
+	addLayerFlags(serveCmd)
+}
+
+func runServe(cmd *cobra.Command, args []string) {
+	root := args[0]
+	keyFile, _ := cmd.Flags().GetString("key")
+	address, _ := cmd.Flags().GetString("address")
+	token, _ := cmd.Flags().GetString("token")
+	dav, _ := cmd.Flags().GetBool("webdav")
+
+	ks, err := getKeySource(keyFile)
+	if err != nil {
+		log.Fatalln("failed to initialize key source:", err)
+	}
+	vol, err := openVolume(cmd, root, ks)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fsys := drmfs.NewFS(vol)
+
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.FS(fsys)))
+	if dav {
+		mux.Handle("/dav/", readOnly(&webdav.Handler{
+			Prefix:     "/dav",
+			FileSystem: davFileSystem{http.FS(fsys)},
+			LockSystem: webdav.NewMemLS(),
+			Logger: func(r *http.Request, err error) {
+				if err != nil {
+					log.Println(r.Method, r.URL.Path+":", err)
+				}
+			},
+		}))
+	}
+
+	var handler http.Handler = mux
+	if token != "" {
+		handler = requireToken(token, handler)
+	}
+
+	log.Println("listening on", address)
+	log.Fatalln(http.ListenAndServe(address, handler))
+}
+
+// name of the cookie that authenticates clients that passed the token as a query parameter
+const tokenCookie = "eapki_token"
+
+func requireToken(token string, next http.Handler) http.Handler {
+	match := func(s string) bool {
+		return subtle.ConstantTimeCompare([]byte(s), []byte(token)) == 1
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, password, basic := r.BasicAuth()
+		bearer, isBearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if (basic && match(password)) || (isBearer && match(bearer)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if cookie, err := r.Cookie(tokenCookie); err == nil && match(cookie.Value) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// links in directory listings don't carry the query parameter
+		if match(r.URL.Query().Get("token")) {
+			http.SetCookie(w, &http.Cookie{
+				Name:     tokenCookie,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteStrictMode,
+			})
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("WWW-Authenticate", `Basic realm="eapki"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
+
+func readOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "PUT", "DELETE", "MKCOL", "COPY", "MOVE", "PROPPATCH":
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// davFileSystem is a read-only webdav.FileSystem
+type davFileSystem struct {
+	fs http.FileSystem
+}
+
+func (dfs davFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return os.ErrPermission
+}
+
+func (dfs davFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, os.ErrPermission
+	}
+	f, err := dfs.open(name)
+	if err != nil {
+		return nil, err
+	}
+	return davFile{f}, nil
+}
+
+func (dfs davFileSystem) RemoveAll(ctx context.Context, name string) error {
+	return os.ErrPermission
+}
+
+func (dfs davFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	return os.ErrPermission
+}
+
+func (dfs davFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	f, err := dfs.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+func (dfs davFileSystem) open(name string) (http.File, error) {
+	// webdav names directories with a trailing slash, which fs.FS doesn't accept
+	return dfs.fs.Open(path.Clean("/" + name))
+}
+
+type davFile struct {
+	http.File
+}
+
+func (f davFile) Write([]byte) (int, error) {
+	return 0, os.ErrPermission
+}
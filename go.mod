@@ -5,12 +5,15 @@ go 1.24.2
 require (
 	github.com/YoshihikoAbe/avsproperty v0.0.1
 	github.com/YoshihikoAbe/fsdump v0.0.1
+	github.com/bmatcuk/doublestar/v4 v4.9.1
+	github.com/klauspost/compress v1.18.0
 	github.com/miekg/pkcs11 v1.1.1
 	github.com/spf13/cobra v1.8.1
+	golang.org/x/net v0.42.0
 )
 
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
-	golang.org/x/text v0.16.0 // indirect
+	golang.org/x/text v0.27.0 // indirect
 )
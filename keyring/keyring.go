@@ -1,6 +1,7 @@
 package keyring
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"encoding/binary"
@@ -53,29 +54,59 @@ type Keyring struct {
 	version string
 }
 
-func New(rd io.ReaderAt, ks KeySource) (*Keyring, error) {
-	var header struct {
-		HeadSize     uint32
-		Code         keyringString
-		Version      keyringString
-		KeyCount     uint32
-		_            uint64
-		MasterOffset uint32
-		MasterSize   uint32
-		EakekOffset  uint32
-		EakekSize    uint32
-	}
+type header struct {
+	HeadSize     uint32
+	Code         keyringString
+	Version      keyringString
+	KeyCount     uint32
+	_            uint64
+	MasterOffset uint32
+	MasterSize   uint32
+	EakekOffset  uint32
+	EakekSize    uint32
+}
 
-	if err := binary.Read(io.NewSectionReader(rd, 0, headerSize), binary.BigEndian, &header); err != nil {
+func readHeader(rd io.ReaderAt) (*header, error) {
+	h := &header{}
+	if err := binary.Read(io.NewSectionReader(rd, 0, headerSize), binary.BigEndian, h); err != nil {
 		return nil, err
 	}
 
-	if header.MasterSize != masterSize {
+	if h.MasterSize != masterSize {
 		return nil, keyringError("invalid master key size")
 	}
-	if header.EakekSize != contentHeaderSize {
+	if h.EakekSize != contentHeaderSize {
 		return nil, keyringError("invalid eakek header size")
 	}
+	return h, nil
+}
+
+// Info contains the unencrypted metadata of a keyring
+type Info struct {
+	Code     string `json:"code"`
+	Version  string `json:"version"`
+	KeyCount int    `json:"key_count"`
+}
+
+// ReadInfo reads the metadata of a keyring without decrypting it
+func ReadInfo(rd io.ReaderAt) (*Info, error) {
+	h, err := readHeader(rd)
+	if err != nil {
+		return nil, err
+	}
+	return &Info{
+		Code:     h.Code.string(),
+		Version:  h.Version.string(),
+		KeyCount: int(h.KeyCount),
+	}, nil
+}
+
+func New(rd io.ReaderAt, ks KeySource) (*Keyring, error) {
+	header, err := readHeader(rd)
+	if err != nil {
+		return nil, err
+	}
+
 	code := header.Code.string()
 	if code != ks.ContentsCode() {
 		return nil, keyringError("invalid contents code")
@@ -121,7 +152,7 @@ func New(rd io.ReaderAt, ks KeySource) (*Keyring, error) {
 	if _, err := rd.ReadAt(master, int64(header.MasterOffset)+152); err != nil {
 		return nil, err
 	}
-	master, err := ks.DecryptKey(master)
+	master, err = ks.DecryptKey(master)
 	if err != nil {
 		return nil, err
 	}
@@ -147,7 +178,48 @@ func New(rd io.ReaderAt, ks KeySource) (*Keyring, error) {
 }
 
 func (kr *Keyring) MakeReader(rd io.Reader, key uint32) (io.Reader, error) {
-	if key > uint32(len(kr.entries)) {
+	cek, err := kr.contentKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return kr.makeContentReader(rd, cek)
+}
+
+// MakeSectionReader returns a reader that provides random access to the
+// decrypted contents of an encrypted file of the given size.
+func (kr *Keyring) MakeSectionReader(rd io.ReaderAt, size int64, key uint32) (*io.SectionReader, error) {
+	cek, err := kr.contentKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, contentHeaderSize)
+	if _, err := rd.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	if err := checkContentHeader(header); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+
+	cr := &contentReaderAt{
+		rd:    rd,
+		block: block,
+		iv:    header[14:],
+	}
+	return io.NewSectionReader(cr, 0, ContentSize(size)), nil
+}
+
+// ContentSize returns the size of the plaintext stored in an encrypted file of the given size.
+func ContentSize(size int64) int64 {
+	return max(size-contentHeaderSize, 0)
+}
+
+func (kr *Keyring) contentKey(key uint32) ([]byte, error) {
+	if key >= uint32(len(kr.entries)) {
 		return nil, keyringError("key not found")
 	}
 	entry := kr.entries[key]
@@ -166,8 +238,7 @@ func (kr *Keyring) MakeReader(rd io.Reader, key uint32) (io.Reader, error) {
 	if _, err := io.ReadFull(crd, cek); err != nil {
 		return nil, err
 	}
-
-	return kr.makeContentReader(rd, cek)
+	return cek, nil
 }
 
 func (kr *Keyring) MasterKey() []byte {
@@ -187,9 +258,8 @@ func (kr *Keyring) makeContentReader(rd io.Reader, key []byte) (io.Reader, error
 	if _, err := io.ReadFull(rd, header); err != nil {
 		return nil, err
 	}
-
-	if header[0] != 6 || header[1] != 3 {
-		return nil, keyringError("invalid encrypted file header")
+	if err := checkContentHeader(header); err != nil {
+		return nil, err
 	}
 
 	block, err := aes.NewCipher(key)
@@ -199,3 +269,42 @@ func (kr *Keyring) makeContentReader(rd io.Reader, key []byte) (io.Reader, error
 
 	return &cipher.StreamReader{S: cipher.NewCTR(block, header[14:]), R: rd}, nil
 }
+
+func checkContentHeader(header []byte) error {
+	if header[0] != 6 || header[1] != 3 {
+		return keyringError("invalid encrypted file header")
+	}
+	return nil
+}
+
+// contentReaderAt decrypts arbitrary ranges of an encrypted file by
+// seeking the CTR keystream to the requested offset
+type contentReaderAt struct {
+	rd    io.ReaderAt
+	block cipher.Block
+	iv    []byte
+}
+
+func (cr *contentReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, keyringError("negative offset")
+	}
+	n, err := cr.rd.ReadAt(p, off+contentHeaderSize)
+
+	stream := cipher.NewCTR(cr.block, counterAt(cr.iv, uint64(off/aes.BlockSize)))
+	skip := make([]byte, off%aes.BlockSize)
+	stream.XORKeyStream(skip, skip)
+	stream.XORKeyStream(p[:n], p[:n])
+	return n, err
+}
+
+// counterAt returns the CTR counter block used to encrypt the nth block of a file
+func counterAt(iv []byte, n uint64) []byte {
+	ctr := bytes.Clone(iv)
+	for i := len(ctr) - 1; i >= 0 && n != 0; i-- {
+		n += uint64(ctr[i])
+		ctr[i] = byte(n)
+		n >>= 8
+	}
+	return ctr
+}
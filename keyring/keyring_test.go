@@ -0,0 +1,53 @@
+package keyring
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+	"testing"
+)
+
+func TestContentReaderAt(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	header := make([]byte, contentHeaderSize)
+	header[0], header[1] = 6, 3
+	for i := range header[14:] {
+		// start close to a byte boundary so that the counter carries
+		header[14+i] = 0xff - byte(i%3)
+	}
+
+	plain := make([]byte, 1000)
+	for i := range plain {
+		plain[i] = byte(i * 7)
+	}
+	block, _ := aes.NewCipher(key)
+	enc := make([]byte, len(plain))
+	cipher.NewCTR(block, header[14:]).XORKeyStream(enc, plain)
+	file := append(bytes.Clone(header), enc...)
+
+	kr := &Keyring{}
+	rd, err := kr.makeContentReader(bytes.NewReader(file), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(rd); !bytes.Equal(b, plain) {
+		t.Fatal("sequential read returned invalid plaintext")
+	}
+
+	cr := &contentReaderAt{
+		rd:    bytes.NewReader(file),
+		block: block,
+		iv:    header[14:],
+	}
+	for _, off := range []int64{0, 1, 15, 16, 17, 255, 256, 511, 999} {
+		b := make([]byte, 37)
+		n, err := cr.ReadAt(b, off)
+		if err != nil && err != io.EOF {
+			t.Fatalf("(%d): %v", off, err)
+		}
+		if !bytes.Equal(b[:n], plain[off:min(off+37, int64(len(plain)))]) {
+			t.Fatalf("(%d): invalid plaintext", off)
+		}
+	}
+}